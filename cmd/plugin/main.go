@@ -4,13 +4,17 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	"github.com/dancavallaro/kubectl-unmount/pkg/discovery"
 	"github.com/dancavallaro/kubectl-unmount/pkg/plugin"
+	"github.com/dancavallaro/kubectl-unmount/pkg/scaling"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/cli-runtime/pkg/resource"
 
 	// Import cloud auth providers
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
@@ -18,6 +22,8 @@ import (
 
 var (
 	config *plugin.ConfigFlags
+	// configFile is the path of the config file, or empty to look for unmount.yaml in ~/.kube
+	configFile string
 
 	// Injected by goreleaser via ldflags at build-time
 	version = "dev"
@@ -25,31 +31,65 @@ var (
 	date    = "unknown"
 )
 
+// Exit codes which let automation distinguish why the plugin failed.
+const (
+	exitCodeError       = 1
+	exitCodeTimeout     = 124
+	exitCodeInterrupted = 130
+)
+
 func main() {
 	if err := RootCmd().Execute(); err != nil {
 		_, _ = fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
+	}
+}
+
+func exitCode(err error) int {
+	var timeoutErr *plugin.TimeoutError
+	switch {
+	case errors.As(err, &timeoutErr):
+		return exitCodeTimeout
+	case errors.Is(err, plugin.ErrInterrupted):
+		return exitCodeInterrupted
+	default:
+		return exitCodeError
 	}
 }
 
+// unwrapError strips the outermost layer of context from errors returned by the plugin, falling back to
+// the error itself if it doesn't wrap anything.
+func unwrapError(err error) error {
+	if unwrapped := errors.Unwrap(err); unwrapped != nil {
+		return unwrapped
+	}
+	return err
+}
+
 func RootCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:           "kubectl unmount",
-		Short:         "Unmount all PersistentVolumes of a particular StorageClass",
+		Use:   "kubectl unmount",
+		Short: "Unmount all PersistentVolumes of a particular StorageClass",
+		Example: `  kubectl unmount --storage-class=standard
+  kubectl unmount -n my-namespace pvc/data-0 pvc/data-1
+  kubectl unmount ns-a/data ns-b/data
+  kubectl unmount -f pvcs.yaml`,
+		Args:          cobra.ArbitraryArgs,
 		SilenceErrors: true,
 		SilenceUsage:  true,
 		PreRunE: func(cmd *cobra.Command, args []string) error {
 			return viper.BindPFlags(cmd.Flags())
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if *config.Namespace == "" && *config.StorageClass == "" {
-				return errors.New("you must specify at least one of --namespace or --storage-class")
+			config.PVCArgs = args
+			if err := loadConfig(); err != nil {
+				return err
 			}
-			if *config.StorageClass != "" && *config.PVCName != "" {
-				return errors.New("cannot specify both --storage-class and --pvc-name")
+			if err := validateFilters(); err != nil {
+				return err
 			}
 			if err := plugin.RunPlugin(config); err != nil {
-				return errors.Unwrap(err)
+				return unwrapError(err)
 			}
 			return nil
 		},
@@ -68,26 +108,206 @@ func RootCmd() *cobra.Command {
 	}
 	cmd.AddCommand(versionCmd)
 
+	restoreCmd := &cobra.Command{
+		Use:   "restore",
+		Short: "Scale previously unmounted workloads back to their original replica counts",
+		Args:  cobra.ArbitraryArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return viper.BindPFlags(cmd.Flags())
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config.PVCArgs = args
+			if err := loadConfig(); err != nil {
+				return err
+			}
+			if err := validateFilters(); err != nil {
+				return err
+			}
+			if err := plugin.RunRestore(config); err != nil {
+				return unwrapError(err)
+			}
+			return nil
+		},
+	}
+	cmd.AddCommand(restoreCmd)
+
 	cobra.OnInitialize(initConfig)
 	config = &plugin.ConfigFlags{
-		ConfigFlags:  *genericclioptions.NewConfigFlags(false),
-		Confirmed:    common.BoolP(false),
-		DryRun:       common.BoolP(false),
-		PVCName:      common.StringP(""),
-		StorageClass: common.StringP(""),
+		ConfigFlags:         *genericclioptions.NewConfigFlags(false),
+		Confirmed:           common.BoolP(false),
+		DryRun:              common.StringP(string(scaling.DryRunNone)),
+		PVCName:             common.StringP(""),
+		StorageClass:        common.StringP(""),
+		Selector:            common.StringP(""),
+		AccessModes:         &[]string{},
+		CSIDriver:           common.StringP(""),
+		Phase:               common.StringP(""),
+		MinCapacity:         common.StringP(""),
+		MaxCapacity:         common.StringP(""),
+		WaitForDetach:       common.BoolP(true),
+		Timeout:             common.DurationP(0),
+		PrintFlags:          genericclioptions.NewPrintFlags(""),
+		Filenames:           &resource.FilenameOptions{},
+		JobAction:           common.StringP(string(scaling.JobActionSuspend)),
+		FenceDaemonSets:     common.BoolP(false),
+		PauseAutoscalers:    common.BoolP(true),
+		GitOps:              common.StringP(string(plugin.GitOpsModeRefuse)),
+		ArgoCDNamespace:     common.StringP("argocd"),
+		Atomic:              common.BoolP(false),
+		Parallelism:         common.IntP(1),
+		QPS:                 common.Float32P(0),
+		Burst:               common.IntP(0),
+		PageSize:            common.Int64P(500),
+		FromCache:           common.BoolP(false),
+		ProtectedNamespaces: &[]string{},
+		IgnoreProtection:    common.BoolP(false),
+		MaxControllers:      common.IntP(0),
+		MaxPods:             common.IntP(0),
+		MaxNamespaces:       common.IntP(0),
+		Preflight:           common.BoolP(true),
+		MaxOwnerDepth:       common.IntP(discovery.DefaultMaxOwnerDepth),
+		OwnerLevel:          common.IntP(0),
+		PVNames:             &[]string{},
+		VolumeHandles:       &[]string{},
 	}
 
-	cmd.Flags().StringVar(config.PVCName, "pvc", "", "Unmount a specific PVC")
-	cmd.Flags().StringVarP(config.StorageClass, "storage-class", "c", "", "Unmount PVs of a specific storage class")
-	cmd.Flags().BoolVarP(config.DryRun, "dry-run", "d", false,
-		"Print summary of controllers that would be scaled down, but *don't* modify anything")
-	cmd.Flags().BoolVarP(config.Confirmed, "yes", "y", false, "Skip confirmation prompt and proceed with scaling down pods")
-	config.AddFlags(cmd.Flags())
+	// Flags are persistent so that the restore command selects volumes in exactly the same way
+	cmd.PersistentFlags().StringVar(config.PVCName, "pvc", "", "Unmount a specific PVC")
+	cmd.PersistentFlags().StringVarP(config.StorageClass, "storage-class", "c", "", "Unmount PVs of a specific storage class")
+	cmd.PersistentFlags().StringSliceVar(config.PVNames, "pv", nil,
+		"Unmount the PVCs bound to these PersistentVolumes")
+	cmd.PersistentFlags().StringSliceVar(config.VolumeHandles, "volume-handle", nil,
+		"Unmount the PVCs bound to PersistentVolumes with these CSI volume handles")
+	cmd.PersistentFlags().StringSliceVarP(&config.Filenames.Filenames, "filename", "f", nil,
+		"Unmount the PVCs defined in these files")
+	cmd.PersistentFlags().BoolVarP(&config.Filenames.Recursive, "recursive", "R", false,
+		"Process the directory used in -f, --filename recursively")
+	cmd.PersistentFlags().StringVarP(config.Selector, "selector", "l", "",
+		"Unmount PVCs matching a label selector (e.g. -l key1=value1,key2=value2)")
+	cmd.PersistentFlags().StringSliceVar(config.AccessModes, "access-mode", nil,
+		"Unmount PVCs with any of these access modes (RWO, ROX, RWX, RWOP)")
+	cmd.PersistentFlags().StringVar(config.CSIDriver, "csi-driver", "", "Unmount PVCs bound to PVs of a specific CSI driver")
+	cmd.PersistentFlags().StringVar(config.Phase, "phase", "", "Unmount PVCs in a specific phase (Pending, Bound or Lost)")
+	cmd.PersistentFlags().StringVar(config.MinCapacity, "min-capacity", "",
+		"Unmount PVCs requesting at least this much storage (e.g. 10Gi)")
+	cmd.PersistentFlags().StringVar(config.MaxCapacity, "max-capacity", "",
+		"Unmount PVCs requesting at most this much storage (e.g. 100Gi)")
+	cmd.PersistentFlags().StringVarP(config.DryRun, "dry-run", "d", string(scaling.DryRunNone),
+		"Print summary of controllers that would be scaled down, but *don't* modify anything. One of: none, "+
+			"client (skip every change), or server (send every change as a server-side dry run, to catch RBAC "+
+			"denials and admission webhook rejections)")
+	cmd.PersistentFlags().Lookup("dry-run").NoOptDefVal = string(scaling.DryRunClient)
+	cmd.PersistentFlags().BoolVarP(config.Confirmed, "yes", "y", false, "Skip confirmation prompt and proceed with scaling down pods")
+	cmd.PersistentFlags().DurationVar(config.Timeout, "timeout", 0,
+		"Give up if the operation hasn't completed this long after it was confirmed, e.g. 5m (0 means no timeout)")
+	plugin.AddOutputFlags(cmd, config.PrintFlags)
+	cmd.Flags().StringVar(config.JobAction, "job-action", string(scaling.JobActionSuspend),
+		"What to do with Jobs mounting the volumes: suspend (resumed on restore), wait for completion, or delete")
+	cmd.Flags().BoolVar(config.FenceDaemonSets, "fence-daemonsets", false,
+		"Stop DaemonSets by temporarily adding a nodeSelector which matches no nodes (undone by restore)")
+	cmd.Flags().BoolVar(config.PauseAutoscalers, "pause-autoscalers", true,
+		"Pause HorizontalPodAutoscalers and KEDA ScaledObjects targeting scaled down controllers (resumed on restore)")
+	cmd.Flags().StringVar(config.GitOps, "gitops", string(plugin.GitOpsModeRefuse),
+		"What to do with controllers managed by Argo CD or Flux: refuse to proceed, suspend reconciliation (resumed on restore), or ignore")
+	cmd.PersistentFlags().StringVar(config.ArgoCDNamespace, "argocd-namespace", "argocd",
+		"Namespace of Argo CD Applications")
+	cmd.Flags().BoolVar(config.Atomic, "atomic", false,
+		"If scaling down any controller fails, or waiting times out, roll back every controller already scaled down "+
+			"(with --dry-run=server nothing was recorded, so the rollback finds nothing to restore)")
+	cmd.Flags().StringSliceVar(config.ProtectedNamespaces, "protected-namespaces", plugin.DefaultProtectedNamespaces,
+		"Namespaces whose PVCs are never unmounted (PVCs and controllers can also opt out with the "+
+			common.AnnotationProtect+"=true annotation)")
+	cmd.Flags().BoolVar(config.IgnoreProtection, "ignore-protection", false,
+		"Unmount protected namespaces, PVCs and controllers anyway")
+	cmd.Flags().IntVar(config.MaxControllers, "max-controllers", 0,
+		"Abort without changing anything if more than this many controllers would be scaled down (0 means no limit)")
+	cmd.Flags().IntVar(config.MaxPods, "max-pods", 0,
+		"Abort without changing anything if more than this many pods would be stopped (0 means no limit)")
+	cmd.Flags().IntVar(config.MaxNamespaces, "max-namespaces", 0,
+		"Abort without changing anything if pods in more than this many namespaces would be stopped (0 means no limit)")
+	cmd.Flags().BoolVar(config.Preflight, "preflight", true,
+		"Before asking for confirmation, check that every required permission is granted and abort if any is missing")
+	cmd.Flags().IntVar(config.MaxOwnerDepth, "max-owner-depth", discovery.DefaultMaxOwnerDepth,
+		"Maximum number of controller owner references to follow up from each pod")
+	cmd.Flags().IntVar(config.OwnerLevel, "owner-level", 0,
+		"Which owner of each pod to act on, counting up from the pod's controller (1), or 0 for the top-level owner")
+	cmd.Flags().BoolVar(config.WaitForDetach, "wait-for-detach", true,
+		"After pods are gone, wait until their PersistentVolumes are detached from every node")
+	cmd.PersistentFlags().IntVar(config.Parallelism, "parallelism", 1,
+		"Number of controllers to look up and scale down concurrently")
+	cmd.PersistentFlags().Float32Var(config.QPS, "qps", 0,
+		"Maximum queries per second to the API server (0 uses the client default)")
+	cmd.PersistentFlags().IntVar(config.Burst, "burst", 0,
+		"Maximum burst of queries to the API server (0 uses the client default)")
+	cmd.PersistentFlags().Int64Var(config.PageSize, "page-size", 500,
+		"Number of objects to request per page when listing resources")
+	cmd.PersistentFlags().BoolVar(config.FromCache, "from-cache", false,
+		"List resources from the API server's watch cache (resourceVersion=0), which is cheaper but may be slightly stale")
+	cmd.PersistentFlags().StringVar(&configFile, "config", "",
+		"Config file declaring handler rules for custom controllers (default ~/.kube/unmount.yaml)")
+	config.AddFlags(cmd.PersistentFlags())
 
 	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
 	return cmd
 }
 
+func validateFilters() error {
+	explicitPVCs := len(config.PVCArgs) > 0 || len(config.Filenames.Filenames) > 0
+	explicitPVs := len(*config.PVNames) > 0 || len(*config.VolumeHandles) > 0
+	if explicitPVCs && explicitPVs {
+		return errors.New("cannot combine PVC arguments or --filename with --pv or --volume-handle")
+	}
+	if explicitPVCs || explicitPVs {
+		if *config.PVCName != "" || hasPVCFilters() {
+			return errors.New("cannot combine explicitly selected volumes with --pvc or PVC filters")
+		}
+		return nil
+	}
+	if *config.Namespace == "" && *config.StorageClass == "" && *config.Selector == "" {
+		return errors.New("you must specify at least one of --namespace, --storage-class or --selector")
+	}
+	if *config.PVCName != "" && hasPVCFilters() {
+		return errors.New("cannot combine --pvc with --storage-class or other PVC filters")
+	}
+	return nil
+}
+
+// hasPVCFilters returns true if any flag which filters the set of PVCs was specified.
+func hasPVCFilters() bool {
+	return *config.StorageClass != "" || *config.Selector != "" || len(*config.AccessModes) > 0 ||
+		*config.CSIDriver != "" || *config.Phase != "" || *config.MinCapacity != "" || *config.MaxCapacity != ""
+}
+
 func initConfig() {
 	viper.AutomaticEnv()
+	if configFile != "" {
+		viper.SetConfigFile(configFile)
+	} else if home, err := os.UserHomeDir(); err == nil {
+		viper.AddConfigPath(filepath.Join(home, ".kube"))
+		viper.SetConfigName("unmount")
+		viper.SetConfigType("yaml")
+	}
+}
+
+// loadConfig reads the config file, if there is one, and the handler rules declared in it.
+func loadConfig() error {
+	if err := viper.ReadInConfig(); err != nil {
+		var notFound viper.ConfigFileNotFoundError
+		if errors.As(err, &notFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var rules []scaling.HandlerRule
+	if err := viper.UnmarshalKey("handlers", &rules); err != nil {
+		return fmt.Errorf("failed to parse handlers in %s: %w", viper.ConfigFileUsed(), err)
+	}
+	for i, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("invalid handler %d (%v) in %s: %w", i+1, rule, viper.ConfigFileUsed(), err)
+		}
+	}
+	config.HandlerRules = rules
+	return nil
 }
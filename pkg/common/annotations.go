@@ -0,0 +1,41 @@
+package common
+
+import metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+const (
+	// AnnotationProtect opts a PVC or controller out of being unmounted, when set to "true".
+	AnnotationProtect = "unmount.kubectl.io/protect"
+
+	// AnnotationOriginalReplicas records the replica count a controller had before it was scaled down,
+	// so that it can later be restored.
+	AnnotationOriginalReplicas = "unmount.kubectl.io/original-replicas"
+	// AnnotationOriginalSuspend records the value of spec.suspend of a CronJob, Job, or Flux Kustomization or
+	// HelmRelease before it was suspended.
+	AnnotationOriginalSuspend = "unmount.kubectl.io/original-suspend"
+	// AnnotationOriginalTemplate records the pod template (as JSON) of a DaemonSet before it was fenced.
+	AnnotationOriginalTemplate = "unmount.kubectl.io/original-template"
+	// AnnotationOriginalScaleTarget records the scaleTargetRef (as JSON) of a HorizontalPodAutoscaler before it
+	// was paused.
+	AnnotationOriginalScaleTarget = "unmount.kubectl.io/original-scale-target"
+	// AnnotationOriginalPausedReplicas records KEDA's paused-replicas annotation on a ScaledObject before it was
+	// paused, or an empty string if it wasn't set.
+	AnnotationOriginalPausedReplicas = "unmount.kubectl.io/original-paused-replicas"
+	// AnnotationOriginalAutomatedSync records spec.syncPolicy.automated (as JSON) of an Argo CD Application
+	// before automated sync was disabled.
+	AnnotationOriginalAutomatedSync = "unmount.kubectl.io/original-automated-sync"
+	// AnnotationOriginalOperatorState records the value (as JSON, or null if it wasn't set) of the field an
+	// operator's custom resource was quiesced with, e.g. CloudNativePG's hibernation annotation. Resources
+	// quiesced by a handler rule record the field the rule restores, or null if it restores with a patch.
+	AnnotationOriginalOperatorState = "unmount.kubectl.io/original-operator-state"
+	// AnnotationOriginalRunStrategy records spec.runStrategy, or spec.running on older versions, of a KubeVirt
+	// VirtualMachine before it was halted, as a JSON object of the original field.
+	AnnotationOriginalRunStrategy = "unmount.kubectl.io/original-run-strategy"
+	// AnnotationPVCs records the comma-separated names of the PVCs mounted by the pods of a resource quiesced
+	// by a handler rule, since there's no way of telling which volumes an arbitrary resource uses on restore.
+	AnnotationPVCs = "unmount.kubectl.io/pvcs"
+)
+
+// IsProtected returns true if the object has opted out of being unmounted with AnnotationProtect.
+func IsProtected(obj metav1.Object) bool {
+	return obj.GetAnnotations()[AnnotationProtect] == "true"
+}
@@ -1,9 +1,37 @@
 package common
 
+import (
+	"maps"
+	"slices"
+)
+
 const (
 	KindPod         = "Pod"
 	KindReplicaSet  = "ReplicaSet"
 	KindDeployment  = "Deployment"
 	KindDaemonSet   = "DaemonSet"
 	KindStatefulSet = "StatefulSet"
+	KindJob         = "Job"
+	KindCronJob     = "CronJob"
+
+	KindReplicationController = "ReplicationController"
+
+	KindPersistentVolumeClaim = "PersistentVolumeClaim"
 )
+
+// builtinGroups are the API groups of the built-in kinds which controllers are handled as.
+var builtinGroups = map[string]string{
+	KindPod:         "",
+	KindReplicaSet:  "apps",
+	KindDeployment:  "apps",
+	KindDaemonSet:   "apps",
+	KindStatefulSet: "apps",
+	KindJob:         "batch",
+	KindCronJob:     "batch",
+}
+
+// IsBuiltinGroup returns whether the API group is that of a built-in kind, whose controllers are managed by
+// Kubernetes itself.
+func IsBuiltinGroup(group string) bool {
+	return slices.Contains(slices.Collect(maps.Values(builtinGroups)), group)
+}
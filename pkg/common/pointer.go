@@ -1,5 +1,7 @@
 package common
 
+import "time"
+
 func StringP(val string) *string {
 	return &val
 }
@@ -7,3 +9,23 @@ func StringP(val string) *string {
 func BoolP(val bool) *bool {
 	return &val
 }
+
+func DurationP(val time.Duration) *time.Duration {
+	return &val
+}
+
+func Int32P(val int32) *int32 {
+	return &val
+}
+
+func IntP(val int) *int {
+	return &val
+}
+
+func Float32P(val float32) *float32 {
+	return &val
+}
+
+func Int64P(val int64) *int64 {
+	return &val
+}
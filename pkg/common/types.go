@@ -1,14 +1,54 @@
 package common
 
-import "fmt"
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
 
 // ControllerRef represents a Kubernetes controller that owns a pod
 type ControllerRef struct {
-	Kind      string
-	Namespace string
-	Name      string
+	// APIVersion is the group and version of the controller, e.g. "apps/v1"
+	APIVersion string
+	Kind       string
+	Namespace  string
+	Name       string
+}
+
+// GroupVersionKind returns the GVK of the controller. If the APIVersion is unset or invalid, only the kind
+// is set.
+func (ref ControllerRef) GroupVersionKind() schema.GroupVersionKind {
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return schema.GroupVersionKind{Kind: ref.Kind}
+	}
+	return gv.WithKind(ref.Kind)
+}
+
+// BuiltinKind returns the controller's kind if it's one of the built-in kinds in its own API group, or an
+// empty string for any other kind, e.g. a custom resource which happens to be called Deployment. Controllers
+// without an APIVersion are assumed to be built-in.
+func (ref ControllerRef) BuiltinKind() string {
+	group, ok := builtinGroups[ref.Kind]
+	if !ok || (ref.APIVersion != "" && ref.GroupVersionKind().Group != group) {
+		return ""
+	}
+	return ref.Kind
 }
 
 func (ref ControllerRef) String() string {
 	return fmt.Sprintf("%s/%s/%s", ref.Kind, ref.Namespace, ref.Name)
 }
+
+// VolumeRef represents a PersistentVolume and the PVC it's bound to
+type VolumeRef struct {
+	Name           string
+	ClaimNamespace string
+	ClaimName      string
+	CSIDriver      string
+	VolumeHandle   string
+}
+
+func (ref VolumeRef) String() string {
+	return fmt.Sprintf("%s (%s/%s)", ref.Name, ref.ClaimNamespace, ref.ClaimName)
+}
@@ -0,0 +1,186 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// FindBoundVolumes resolves the given PVCs to the PersistentVolumes they're bound to, listing PVs once. Unbound
+// PVCs are ignored, since there's nothing attached to wait for.
+func (f *Finder) FindBoundVolumes(ctx context.Context, pvcsPerNs map[string][]string) ([]common.VolumeRef, error) {
+	var volumes []common.VolumeRef
+
+	err := f.eachPersistentVolume(ctx, func(pv *corev1.PersistentVolume) error {
+		claim, ok := boundClaim(*pv)
+		if !ok || !slices.Contains(pvcsPerNs[claim.Namespace], claim.Name) {
+			return nil
+		}
+		ref := common.VolumeRef{
+			Name:           pv.Name,
+			ClaimNamespace: claim.Namespace,
+			ClaimName:      claim.Name,
+		}
+		if pv.Spec.CSI != nil {
+			ref.CSIDriver = pv.Spec.CSI.Driver
+			ref.VolumeHandle = pv.Spec.CSI.VolumeHandle
+		}
+		volumes = append(volumes, ref)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return volumes, nil
+}
+
+// AttachmentIndex is a cache of VolumeAttachments, and of the nodes which the volumes being waited on may be
+// attached to. It's kept up to date by watches, so it can be queried repeatedly without listing them again.
+type AttachmentIndex struct {
+	volumes     []common.VolumeRef
+	attachments cache.Store
+	nodes       []cache.Store
+	changed     chan struct{}
+}
+
+// IndexAttachments starts watching VolumeAttachments, and the given nodes along with any node a
+// VolumeAttachment of the volumes is on, and returns once the initial lists have been cached. Only those nodes
+// are watched, each by name; they're the ones which can report the volumes in status.volumesInUse. The watches
+// stop when the context is done.
+func (f *Finder) IndexAttachments(ctx context.Context, volumes []common.VolumeRef,
+	nodeNames []string) (*AttachmentIndex, error) {
+	index := &AttachmentIndex{volumes: volumes, changed: make(chan struct{}, 1)}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(f.clientset, 0,
+		informers.WithTransform(stripManagedFields),
+		informers.WithTweakListOptions(f.tweakListOptions))
+	informer := factory.Storage().V1().VolumeAttachments().Informer()
+	if err := index.watch(ctx, factory, informer); err != nil {
+		return nil, fmt.Errorf("failed to watch volume attachments: %w", err)
+	}
+	index.attachments = informer.GetStore()
+
+	nodeNames = slices.Clone(nodeNames)
+	for _, obj := range index.attachments.List() {
+		va := obj.(*storagev1.VolumeAttachment)
+		if index.attaches(va) {
+			nodeNames = append(nodeNames, va.Spec.NodeName)
+		}
+	}
+	slices.Sort(nodeNames)
+	for _, name := range slices.Compact(nodeNames) {
+		if name == "" {
+			continue
+		}
+		factory := informers.NewSharedInformerFactoryWithOptions(f.clientset, 0,
+			informers.WithTransform(stripManagedFields),
+			informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+				opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+				f.tweakListOptions(opts)
+			}))
+		informer := factory.Core().V1().Nodes().Informer()
+		if err := index.watch(ctx, factory, informer); err != nil {
+			return nil, fmt.Errorf("failed to watch node %s: %w", name, err)
+		}
+		index.nodes = append(index.nodes, informer.GetStore())
+	}
+
+	return index, nil
+}
+
+// watch starts the informer, notifying of every change, and waits for its initial list to be cached.
+func (i *AttachmentIndex) watch(ctx context.Context, factory informers.SharedInformerFactory,
+	informer cache.SharedIndexInformer) error {
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(any) { i.notify() },
+		UpdateFunc: func(any, any) { i.notify() },
+		DeleteFunc: func(any) { i.notify() },
+	})
+	if err != nil {
+		return err
+	}
+
+	factory.Start(ctx.Done())
+	for _, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("failed to list")
+		}
+	}
+	return nil
+}
+
+// FindAttachedVolumes returns the subset of the indexed volumes that are still attached to a node, either
+// because a VolumeAttachment references them or because they're listed in a node's status.volumesInUse.
+func (i *AttachmentIndex) FindAttachedVolumes() []common.VolumeRef {
+	attached := make(map[string]bool) // key: PV name
+
+	for _, obj := range i.attachments.List() {
+		va := obj.(*storagev1.VolumeAttachment)
+		if va.Spec.Source.PersistentVolumeName != nil {
+			attached[*va.Spec.Source.PersistentVolumeName] = true
+		}
+	}
+
+	for _, nodes := range i.nodes {
+		for _, obj := range nodes.List() {
+			node := obj.(*corev1.Node)
+			for _, inUse := range node.Status.VolumesInUse {
+				for _, vol := range i.volumes {
+					if isVolumeInUse(string(inUse), vol) {
+						attached[vol.Name] = true
+					}
+				}
+			}
+		}
+	}
+
+	var stillAttached []common.VolumeRef
+	for _, vol := range i.volumes {
+		if attached[vol.Name] {
+			stillAttached = append(stillAttached, vol)
+		}
+	}
+	return stillAttached
+}
+
+// Changed receives a value whenever a watched VolumeAttachment or node is added, updated or deleted.
+// Notifications are coalesced, so it's only a hint to check the index again.
+func (i *AttachmentIndex) Changed() <-chan struct{} {
+	return i.changed
+}
+
+func (i *AttachmentIndex) notify() {
+	select {
+	case i.changed <- struct{}{}:
+	default:
+	}
+}
+
+// attaches returns whether the VolumeAttachment is of one of the indexed volumes.
+func (i *AttachmentIndex) attaches(va *storagev1.VolumeAttachment) bool {
+	pv := va.Spec.Source.PersistentVolumeName
+	return pv != nil && slices.ContainsFunc(i.volumes, func(vol common.VolumeRef) bool { return vol.Name == *pv })
+}
+
+// isVolumeInUse checks whether a node's volumesInUse entry refers to the given volume. CSI volumes are
+// identified as "kubernetes.io/csi/<driver>^<volumeHandle>", while in-tree plugins generally end with the
+// PV name (e.g. "kubernetes.io/local-volume/<pv>").
+func isVolumeInUse(uniqueName string, vol common.VolumeRef) bool {
+	if vol.CSIDriver != "" {
+		return uniqueName == fmt.Sprintf("kubernetes.io/csi/%s^%s", vol.CSIDriver, vol.VolumeHandle)
+	}
+	return strings.HasSuffix(uniqueName, "/"+vol.Name)
+}
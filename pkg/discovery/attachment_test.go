@@ -0,0 +1,171 @@
+package discovery
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	"github.com/dancavallaro/kubectl-unmount/pkg/kube/kubetest"
+	"github.com/dancavallaro/kubectl-unmount/pkg/logger"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/utils/ptr"
+)
+
+func TestFindBoundVolumes(t *testing.T) {
+	pv := func(name, claim string, source corev1.PersistentVolumeSource) *corev1.PersistentVolume {
+		pv := &corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       corev1.PersistentVolumeSpec{PersistentVolumeSource: source},
+		}
+		if claim != "" {
+			pv.Spec.ClaimRef = &corev1.ObjectReference{Namespace: testNamespace, Name: claim}
+			pv.Status.Phase = corev1.VolumeBound
+		}
+		return pv
+	}
+	clients := kubetest.NewClients(
+		pv("csi-pv", "csi-pvc", corev1.PersistentVolumeSource{
+			CSI: &corev1.CSIPersistentVolumeSource{Driver: "ebs.csi.aws.com", VolumeHandle: "vol-123"},
+		}),
+		pv("local-pv", "local-pvc", corev1.PersistentVolumeSource{
+			Local: &corev1.LocalVolumeSource{Path: "/mnt/data"},
+		}),
+		pv("other-pv", "other-pvc", corev1.PersistentVolumeSource{}),
+		pv("available-pv", "", corev1.PersistentVolumeSource{}),
+	)
+	finder := New(clients.Clients, logger.NewLogger(io.Discard), Options{})
+
+	volumes, err := finder.FindBoundVolumes(context.Background(), map[string][]string{
+		testNamespace: {"csi-pvc", "local-pvc", "unbound-pvc"},
+	})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []common.VolumeRef{
+		{Name: "csi-pv", ClaimNamespace: testNamespace, ClaimName: "csi-pvc", CSIDriver: "ebs.csi.aws.com", VolumeHandle: "vol-123"},
+		{Name: "local-pv", ClaimNamespace: testNamespace, ClaimName: "local-pvc"},
+	}, volumes)
+
+	// PVs are listed once, rather than each PVC and PV being looked up
+	var verbs []string
+	for _, action := range clients.FakeClientset.Actions() {
+		verbs = append(verbs, action.GetVerb()+" "+action.GetResource().Resource)
+	}
+	require.Equal(t, []string{"list persistentvolumes"}, verbs)
+}
+
+func newTestVolumeAttachment(pv, node string) *storagev1.VolumeAttachment {
+	return &storagev1.VolumeAttachment{
+		ObjectMeta: metav1.ObjectMeta{Name: pv + "-attachment"},
+		Spec: storagev1.VolumeAttachmentSpec{
+			Attacher: "ebs.csi.aws.com",
+			NodeName: node,
+			Source:   storagev1.VolumeAttachmentSource{PersistentVolumeName: ptr.To(pv)},
+		},
+	}
+}
+
+func newTestNode(name string, inUse ...corev1.UniqueVolumeName) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status:     corev1.NodeStatus{VolumesInUse: inUse},
+	}
+}
+
+func TestAttachmentIndex(t *testing.T) {
+	csiVolume := common.VolumeRef{Name: "csi-pv", CSIDriver: "ebs.csi.aws.com", VolumeHandle: "vol-123"}
+	localVolume := common.VolumeRef{Name: "local-pv"}
+
+	tests := []struct {
+		name string
+		objs []runtime.Object
+		want []common.VolumeRef
+	}{
+		{
+			name: "not attached",
+			objs: []runtime.Object{newTestNode("node-1")},
+		},
+		{
+			name: "VolumeAttachment",
+			objs: []runtime.Object{newTestVolumeAttachment("csi-pv", "node-1")},
+			want: []common.VolumeRef{csiVolume},
+		},
+		{
+			name: "CSI volume in use by a node",
+			objs: []runtime.Object{newTestNode("node-1", "kubernetes.io/csi/ebs.csi.aws.com^vol-123")},
+			want: []common.VolumeRef{csiVolume},
+		},
+		{
+			name: "CSI volume of another driver in use",
+			objs: []runtime.Object{newTestNode("node-1", "kubernetes.io/csi/other.csi.example.com^vol-123")},
+		},
+		{
+			name: "CSI volume is only matched by its unique name",
+			objs: []runtime.Object{newTestNode("node-1", "kubernetes.io/local-volume/csi-pv")},
+		},
+		{
+			name: "in-tree volume in use by a node",
+			objs: []runtime.Object{newTestNode("node-1", "kubernetes.io/local-volume/local-pv")},
+			want: []common.VolumeRef{localVolume},
+		},
+		{
+			name: "both",
+			objs: []runtime.Object{
+				newTestNode("node-1", "kubernetes.io/csi/ebs.csi.aws.com^vol-123", "kubernetes.io/local-volume/local-pv"),
+			},
+			want: []common.VolumeRef{csiVolume, localVolume},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			finder := newTestFinder(tt.objs...)
+
+			index, err := finder.IndexAttachments(ctx, []common.VolumeRef{csiVolume, localVolume}, []string{"node-1"})
+			require.NoError(t, err)
+			require.Equal(t, tt.want, index.FindAttachedVolumes())
+		})
+	}
+}
+
+func TestAttachmentIndexWatchesNodes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	volume := common.VolumeRef{Name: "csi-pv", CSIDriver: "ebs.csi.aws.com", VolumeHandle: "vol-123"}
+	clients := kubetest.NewClients(
+		newTestVolumeAttachment("csi-pv", "node-2"),
+		newTestVolumeAttachment("other-pv", "node-3"),
+		newTestNode("node-1"),
+		newTestNode("node-2", "kubernetes.io/csi/ebs.csi.aws.com^vol-123"),
+	)
+	finder := New(clients.Clients, logger.NewLogger(io.Discard), Options{})
+
+	index, err := finder.IndexAttachments(ctx, []common.VolumeRef{volume}, []string{"node-1", "node-1"})
+	require.NoError(t, err)
+	require.Equal(t, []common.VolumeRef{volume}, index.FindAttachedVolumes())
+
+	// Only the pods' nodes and those the volume is attached to are watched, each selected by name
+	var selectors []string
+	for _, action := range clients.FakeClientset.Actions() {
+		if action.GetVerb() == "list" && action.GetResource().Resource == "nodes" {
+			selectors = append(selectors, action.(k8stesting.ListAction).GetListRestrictions().Fields.String())
+		}
+	}
+	require.Equal(t, []string{"metadata.name=node-1", "metadata.name=node-2"}, selectors)
+
+	// Changes are seen through the watches, without listing again
+	attachments := clients.FakeClientset.StorageV1().VolumeAttachments()
+	require.NoError(t, attachments.Delete(ctx, "csi-pv-attachment", metav1.DeleteOptions{}))
+	node := newTestNode("node-2")
+	_, err = clients.FakeClientset.CoreV1().Nodes().UpdateStatus(ctx, node, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return len(index.FindAttachedVolumes()) == 0 }, 5*time.Second, 10*time.Millisecond)
+	require.Len(t, index.Changed(), 1)
+}
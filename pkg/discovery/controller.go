@@ -2,75 +2,166 @@ package discovery
 
 import (
 	"context"
+	"fmt"
 	"maps"
 	"slices"
+	"strings"
 
 	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	"golang.org/x/sync/errgroup"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
 )
 
-// FindController traces the owner references to find the top-level controller.
-// It walks up the ownership chain (e.g., Pod -> ReplicaSet -> Deployment).
-func (f *Finder) FindController(ctx context.Context, pod corev1.Pod) (common.ControllerRef, error) {
-	// Check if pod has any owner references
-	if len(pod.OwnerReferences) == 0 {
-		// Standalone pod with no controller
-		return common.ControllerRef{
-			Kind:      common.KindPod,
-			Namespace: pod.Namespace,
-			Name:      pod.Name,
-		}, nil
+// OwnerChain is the chain of controllers managing a pod, from the pod's own controller up to the top-level
+// owner (e.g. ReplicaSet -> Deployment). A standalone pod is its own controller.
+type OwnerChain []common.ControllerRef
+
+// Top returns the top-level owner.
+func (c OwnerChain) Top() common.ControllerRef {
+	return c[len(c)-1]
+}
+
+// Level returns the owner at the given level, counting up from the pod's controller (1). Level 0, or any
+// level above the top of the chain, is the top-level owner.
+func (c OwnerChain) Level(level int) common.ControllerRef {
+	if level <= 0 || level > len(c) {
+		return c.Top()
 	}
+	return c[level-1]
+}
 
-	// Get the first owner reference (typically there's only one)
-	owner := pod.OwnerReferences[0]
+// Strings returns the owners formatted as "kind/namespace/name".
+func (c OwnerChain) Strings() []string {
+	owners := make([]string, len(c))
+	for i, owner := range c {
+		owners[i] = owner.String()
+	}
+	return owners
+}
 
-	// If the owner is a ReplicaSet, check if it has a Deployment owner
-	if owner.Kind == common.KindReplicaSet {
-		rs, err := f.clientset.AppsV1().ReplicaSets(pod.Namespace).Get(ctx, owner.Name, metav1.GetOptions{})
+// FindOwnerChain follows the controller owner references from the pod, up to the configured depth. Owners
+// whose kind isn't served by the cluster can't be looked up or acted on, so they're left out and end the
+// chain, unless it's the pod's own controller.
+func (f *Finder) FindOwnerChain(ctx context.Context, pod corev1.Pod) (OwnerChain, error) {
+	owner := metav1.GetControllerOf(&pod)
+	if owner == nil {
+		// Standalone pod with no controller
+		return OwnerChain{{
+			APIVersion: "v1",
+			Kind:       common.KindPod,
+			Namespace:  pod.Namespace,
+			Name:       pod.Name,
+		}}, nil
+	}
+
+	chain := OwnerChain{ownerRef(pod.Namespace, *owner)}
+	for {
+		obj, err := f.getOwner(ctx, chain[len(chain)-1])
+		if meta.IsNoMatchError(err) {
+			if len(chain) > 1 {
+				chain = chain[:len(chain)-1]
+			}
+			break
+		}
 		if err != nil {
-			return common.ControllerRef{}, err
+			return nil, err
 		}
-
-		// Check if ReplicaSet has an owner (likely a Deployment)
-		if len(rs.OwnerReferences) > 0 {
-			rsOwner := rs.OwnerReferences[0]
-			return common.ControllerRef{
-				Kind:      rsOwner.Kind,
-				Namespace: pod.Namespace,
-				Name:      rsOwner.Name,
-			}, nil
+		if len(chain) >= f.maxOwnerDepth {
+			break
+		}
+		owner := metav1.GetControllerOf(obj)
+		if owner == nil {
+			break
 		}
+		chain = append(chain, ownerRef(pod.Namespace, *owner))
+	}
+	return chain, nil
+}
+
+// FindController finds the controller to act on for a pod, at the configured level of its owner chain.
+func (f *Finder) FindController(ctx context.Context, pod corev1.Pod) (common.ControllerRef, error) {
+	chain, err := f.FindOwnerChain(ctx, pod)
+	if err != nil {
+		return common.ControllerRef{}, err
+	}
+	return chain.Level(f.ownerLevel), nil
+}
 
-		// ReplicaSet has no owner, it's the top-level controller
-		return common.ControllerRef{
-			Kind:      common.KindReplicaSet,
-			Namespace: pod.Namespace,
-			Name:      rs.Name,
-		}, nil
+// getOwner gets any owner, as an unstructured object.
+func (f *Finder) getOwner(ctx context.Context, ref common.ControllerRef) (metav1.Object, error) {
+	gvk := ref.GroupVersionKind()
+	mapping, err := f.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	var resource dynamic.ResourceInterface = f.dynamic.Resource(mapping.Resource)
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		resource = f.dynamic.Resource(mapping.Resource).Namespace(ref.Namespace)
+	}
+	obj, err := resource.Get(ctx, ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get owner %v: %w", ref, err)
 	}
+	return obj, nil
+}
 
-	// For other controller types (StatefulSet, DaemonSet, etc.), return as-is
+func ownerRef(namespace string, owner metav1.OwnerReference) common.ControllerRef {
 	return common.ControllerRef{
-		Kind:      owner.Kind,
-		Namespace: pod.Namespace,
-		Name:      owner.Name,
-	}, nil
+		APIVersion: owner.APIVersion,
+		Kind:       owner.Kind,
+		Namespace:  namespace,
+		Name:       owner.Name,
+	}
 }
 
-// FindControllers finds the (deduplicated) top-level controllers for the provided pods.
-func (f *Finder) FindControllers(ctx context.Context, pods []corev1.Pod) ([]common.ControllerRef, error) {
-	f.log.Info("Finding controllers for pods...")
+// PodControllers maps pods (keyed by "namespace/name") to the controllers to act on.
+type PodControllers map[string]common.ControllerRef
+
+// Unique returns the deduplicated controllers, sorted by kind, namespace and name.
+func (pc PodControllers) Unique() []common.ControllerRef {
 	controllers := make(map[string]common.ControllerRef) // key: "kind/namespace/name"
-	for _, pod := range pods {
-		ctrl, err := f.FindController(ctx, pod)
-		if err != nil {
-			f.log.Warn("Failed to find controller for pod %s/%s: %v", pod.Namespace, pod.Name, err)
-			return nil, err
-		}
+	for _, ctrl := range pc {
 		controllers[ctrl.String()] = ctrl
 	}
+	return slices.SortedFunc(maps.Values(controllers), func(a, b common.ControllerRef) int {
+		return strings.Compare(a.String(), b.String())
+	})
+}
 
-	return slices.Collect(maps.Values(controllers)), nil
+// OwnerChains maps pods (keyed by "namespace/name") to their owner chains.
+type OwnerChains map[string]OwnerChain
+
+// FindControllers finds the controllers to act on for the provided pods, along with their whole owner chains.
+func (f *Finder) FindControllers(ctx context.Context, pods []corev1.Pod) (PodControllers, OwnerChains, error) {
+	f.log.Info("Finding controllers for pods...")
+	chains := make([]OwnerChain, len(pods))
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(f.parallelism)
+	for i, pod := range pods {
+		g.Go(func() error {
+			chain, err := f.FindOwnerChain(ctx, pod)
+			if err != nil {
+				f.log.Warn("Failed to find controller for pod %s/%s: %v", pod.Namespace, pod.Name, err)
+				return err
+			}
+			chains[i] = chain
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, nil, err
+	}
+
+	controllers := make(PodControllers)
+	ownerChains := make(OwnerChains)
+	for i, pod := range pods {
+		key := pod.Namespace + "/" + pod.Name
+		controllers[key] = chains[i].Level(f.ownerLevel)
+		ownerChains[key] = chains[i]
+	}
+	return controllers, ownerChains, nil
 }
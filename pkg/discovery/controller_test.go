@@ -0,0 +1,296 @@
+package discovery
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	"github.com/dancavallaro/kubectl-unmount/pkg/kube/kubetest"
+	"github.com/dancavallaro/kubectl-unmount/pkg/logger"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+)
+
+const testNamespace = "test-ns"
+
+func controlledBy(apiVersion, kind, name string) []metav1.OwnerReference {
+	return []metav1.OwnerReference{{APIVersion: apiVersion, Kind: kind, Name: name, Controller: ptr.To(true)}}
+}
+
+func podOwnedBy(owners []metav1.OwnerReference) corev1.Pod {
+	return corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: testNamespace, OwnerReferences: owners}}
+}
+
+func newUnstructured(apiVersion, kind, name string, owners []metav1.OwnerReference) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetNamespace(testNamespace)
+	obj.SetName(name)
+	obj.SetOwnerReferences(owners)
+	return obj
+}
+
+func newTestFinder(objs ...runtime.Object) Finder {
+	return newTestFinderWithOptions(Options{}, objs...)
+}
+
+func newTestFinderWithOptions(opts Options, objs ...runtime.Object) Finder {
+	return New(kubetest.NewClients(objs...).Clients, logger.NewLogger(io.Discard), opts)
+}
+
+func TestFindController(t *testing.T) {
+	tests := []struct {
+		name    string
+		pod     corev1.Pod
+		objs    []runtime.Object
+		want    common.ControllerRef
+		wantErr bool
+	}{
+		{
+			name: "standalone pod",
+			pod:  podOwnedBy(nil),
+			want: common.ControllerRef{APIVersion: "v1", Kind: common.KindPod, Namespace: testNamespace, Name: "test-pod"},
+		},
+		{
+			name: "ReplicaSet without an owner",
+			pod:  podOwnedBy(controlledBy("apps/v1", common.KindReplicaSet, "test-rs")),
+			objs: []runtime.Object{
+				&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "test-rs", Namespace: testNamespace}},
+			},
+			want: common.ControllerRef{APIVersion: "apps/v1", Kind: common.KindReplicaSet, Namespace: testNamespace, Name: "test-rs"},
+		},
+		{
+			name: "ReplicaSet owned by a Deployment",
+			pod:  podOwnedBy(controlledBy("apps/v1", common.KindReplicaSet, "test-rs")),
+			objs: []runtime.Object{
+				&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+					Name:            "test-rs",
+					Namespace:       testNamespace,
+					OwnerReferences: controlledBy("apps/v1", common.KindDeployment, "test-deployment"),
+				}},
+				&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "test-deployment", Namespace: testNamespace}},
+			},
+			want: common.ControllerRef{APIVersion: "apps/v1", Kind: common.KindDeployment, Namespace: testNamespace, Name: "test-deployment"},
+		},
+		{
+			name:    "missing ReplicaSet",
+			pod:     podOwnedBy(controlledBy("apps/v1", common.KindReplicaSet, "test-rs")),
+			wantErr: true,
+		},
+		{
+			name: "Job without an owner",
+			pod:  podOwnedBy(controlledBy("batch/v1", common.KindJob, "test-job")),
+			objs: []runtime.Object{
+				&batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "test-job", Namespace: testNamespace}},
+			},
+			want: common.ControllerRef{APIVersion: "batch/v1", Kind: common.KindJob, Namespace: testNamespace, Name: "test-job"},
+		},
+		{
+			name: "Job owned by a CronJob",
+			pod:  podOwnedBy(controlledBy("batch/v1", common.KindJob, "test-job")),
+			objs: []runtime.Object{
+				&batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+					Name:            "test-job",
+					Namespace:       testNamespace,
+					OwnerReferences: controlledBy("batch/v1", common.KindCronJob, "test-cronjob"),
+				}},
+				&batchv1.CronJob{ObjectMeta: metav1.ObjectMeta{Name: "test-cronjob", Namespace: testNamespace}},
+			},
+			want: common.ControllerRef{APIVersion: "batch/v1", Kind: common.KindCronJob, Namespace: testNamespace, Name: "test-cronjob"},
+		},
+		{
+			name: "missing CronJob",
+			pod:  podOwnedBy(controlledBy("batch/v1", common.KindJob, "test-job")),
+			objs: []runtime.Object{
+				&batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+					Name:            "test-job",
+					Namespace:       testNamespace,
+					OwnerReferences: controlledBy("batch/v1", common.KindCronJob, "test-cronjob"),
+				}},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "missing Job",
+			pod:     podOwnedBy(controlledBy("batch/v1", common.KindJob, "test-job")),
+			wantErr: true,
+		},
+		{
+			name: "controller among several owners",
+			pod: podOwnedBy([]metav1.OwnerReference{
+				{APIVersion: "v1", Kind: "ConfigMap", Name: "test-cm"},
+				{APIVersion: "apps/v1", Kind: common.KindStatefulSet, Name: "test-sts", Controller: ptr.To(true)},
+			}),
+			objs: []runtime.Object{
+				&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "test-sts", Namespace: testNamespace}},
+			},
+			want: common.ControllerRef{APIVersion: "apps/v1", Kind: common.KindStatefulSet, Namespace: testNamespace, Name: "test-sts"},
+		},
+		{
+			name: "owners without a controller",
+			pod:  podOwnedBy([]metav1.OwnerReference{{APIVersion: "apps/v1", Kind: common.KindStatefulSet, Name: "test-sts"}}),
+			want: common.ControllerRef{APIVersion: "v1", Kind: common.KindPod, Namespace: testNamespace, Name: "test-pod"},
+		},
+		{
+			name: "StatefulSet",
+			pod:  podOwnedBy(controlledBy("apps/v1", common.KindStatefulSet, "test-sts")),
+			objs: []runtime.Object{
+				&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "test-sts", Namespace: testNamespace}},
+			},
+			want: common.ControllerRef{APIVersion: "apps/v1", Kind: common.KindStatefulSet, Namespace: testNamespace, Name: "test-sts"},
+		},
+		{
+			name: "ReplicaSet owned by a kind which isn't served",
+			pod:  podOwnedBy(controlledBy("apps/v1", common.KindReplicaSet, "test-rs")),
+			objs: []runtime.Object{
+				&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+					Name:            "test-rs",
+					Namespace:       testNamespace,
+					OwnerReferences: controlledBy("unserved.example.com/v1", "Application", "test-app"),
+				}},
+			},
+			want: common.ControllerRef{APIVersion: "apps/v1", Kind: common.KindReplicaSet, Namespace: testNamespace, Name: "test-rs"},
+		},
+		{
+			name: "pod controlled by a kind which isn't served",
+			pod:  podOwnedBy(controlledBy("unserved.example.com/v1", "Application", "test-app")),
+			want: common.ControllerRef{APIVersion: "unserved.example.com/v1", Kind: "Application", Namespace: testNamespace, Name: "test-app"},
+		},
+		{
+			name: "virt-launcher pod of a KubeVirt VirtualMachine",
+			pod:  podOwnedBy(controlledBy("kubevirt.io/v1", "VirtualMachineInstance", "test-vm")),
+			objs: []runtime.Object{
+				newUnstructured("kubevirt.io/v1", "VirtualMachineInstance", "test-vm",
+					controlledBy("kubevirt.io/v1", "VirtualMachine", "test-vm")),
+				newUnstructured("kubevirt.io/v1", "VirtualMachine", "test-vm", nil),
+			},
+			want: common.ControllerRef{APIVersion: "kubevirt.io/v1", Kind: "VirtualMachine", Namespace: testNamespace, Name: "test-vm"},
+		},
+		{
+			name: "DaemonSet",
+			pod:  podOwnedBy(controlledBy("apps/v1", common.KindDaemonSet, "test-ds")),
+			objs: []runtime.Object{
+				&appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "test-ds", Namespace: testNamespace}},
+			},
+			want: common.ControllerRef{APIVersion: "apps/v1", Kind: common.KindDaemonSet, Namespace: testNamespace, Name: "test-ds"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			finder := newTestFinder(tt.objs...)
+			got, err := finder.FindController(context.Background(), tt.pod)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestFindControllers(t *testing.T) {
+	rs := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+		Name:            "test-rs",
+		Namespace:       testNamespace,
+		OwnerReferences: controlledBy("apps/v1", common.KindDeployment, "test-deployment"),
+	}}
+	pods := []corev1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: "pod-a", Namespace: testNamespace, OwnerReferences: controlledBy("apps/v1", common.KindReplicaSet, "test-rs")}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "pod-b", Namespace: testNamespace, OwnerReferences: controlledBy("apps/v1", common.KindReplicaSet, "test-rs")}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "pod-c", Namespace: testNamespace}},
+	}
+
+	finder := newTestFinder(rs, &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "test-deployment", Namespace: testNamespace}})
+	controllers, chains, err := finder.FindControllers(context.Background(), pods)
+	require.NoError(t, err)
+	require.Len(t, controllers, 3)
+	require.Len(t, chains, 3)
+
+	deployment := common.ControllerRef{APIVersion: "apps/v1", Kind: common.KindDeployment, Namespace: testNamespace, Name: "test-deployment"}
+	pod := common.ControllerRef{APIVersion: "v1", Kind: common.KindPod, Namespace: testNamespace, Name: "pod-c"}
+	require.Equal(t, deployment, controllers[testNamespace+"/pod-a"])
+	require.Equal(t, deployment, controllers[testNamespace+"/pod-b"])
+	require.Equal(t, []common.ControllerRef{deployment, pod}, controllers.Unique())
+	require.Equal(t, []string{"ReplicaSet/test-ns/test-rs", "Deployment/test-ns/test-deployment"}, chains[testNamespace+"/pod-a"].Strings())
+}
+
+func TestFindOwnerChain(t *testing.T) {
+	// Pod -> ReplicaSet -> Deployment -> Widget -> a custom resource which isn't served, so can't be looked up
+	objs := []runtime.Object{
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+			Name:            "test-rs",
+			Namespace:       testNamespace,
+			OwnerReferences: controlledBy("apps/v1", common.KindDeployment, "test-deployment"),
+		}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{
+			Name:            "test-deployment",
+			Namespace:       testNamespace,
+			OwnerReferences: controlledBy("example.com/v1", "Widget", "test-widget"),
+		}},
+		newUnstructured("example.com/v1", "Widget", "test-widget", controlledBy("unserved.example.com/v1", "Application", "test-app")),
+	}
+	pod := podOwnedBy(controlledBy("apps/v1", common.KindReplicaSet, "test-rs"))
+	rs := common.ControllerRef{APIVersion: "apps/v1", Kind: common.KindReplicaSet, Namespace: testNamespace, Name: "test-rs"}
+	deployment := common.ControllerRef{APIVersion: "apps/v1", Kind: common.KindDeployment, Namespace: testNamespace, Name: "test-deployment"}
+	widget := common.ControllerRef{APIVersion: "example.com/v1", Kind: "Widget", Namespace: testNamespace, Name: "test-widget"}
+
+	tests := []struct {
+		name           string
+		opts           Options
+		wantChain      OwnerChain
+		wantController common.ControllerRef
+	}{
+		{
+			name:           "whole chain",
+			wantChain:      OwnerChain{rs, deployment, widget},
+			wantController: widget,
+		},
+		{
+			name:           "limited depth",
+			opts:           Options{MaxOwnerDepth: 2},
+			wantChain:      OwnerChain{rs, deployment},
+			wantController: deployment,
+		},
+		{
+			name:           "pod's controller",
+			opts:           Options{OwnerLevel: 1},
+			wantChain:      OwnerChain{rs, deployment, widget},
+			wantController: rs,
+		},
+		{
+			name:           "middle of the chain",
+			opts:           Options{OwnerLevel: 2},
+			wantChain:      OwnerChain{rs, deployment, widget},
+			wantController: deployment,
+		},
+		{
+			name:           "level above the top",
+			opts:           Options{OwnerLevel: 5},
+			wantChain:      OwnerChain{rs, deployment, widget},
+			wantController: widget,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			finder := newTestFinderWithOptions(tt.opts, objs...)
+			chain, err := finder.FindOwnerChain(context.Background(), pod)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantChain, chain)
+
+			ctrl, err := finder.FindController(context.Background(), pod)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantController, ctrl)
+		})
+	}
+}
@@ -1,20 +1,59 @@
 package discovery
 
 import (
+	"github.com/dancavallaro/kubectl-unmount/pkg/kube"
 	"github.com/dancavallaro/kubectl-unmount/pkg/logger"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 )
 
 // Finder handles Kubernetes resource discovery operations.
 type Finder struct {
-	clientset *kubernetes.Clientset
+	clientset kubernetes.Interface
+	dynamic   dynamic.Interface
+	discovery discovery.DiscoveryInterface
+	mapper    meta.RESTMapper
 	log       *logger.Logger
+
+	parallelism   int
+	paging        kube.Paging
+	maxOwnerDepth int
+	ownerLevel    int
+}
+
+// Options configures how a Finder queries the cluster.
+type Options struct {
+	// Parallelism is the number of concurrent lookups, e.g. of pod owners (at least 1)
+	Parallelism int
+	Paging      kube.Paging
+	// MaxOwnerDepth is how many controller owner references are followed up from a pod (defaults to
+	// DefaultMaxOwnerDepth)
+	MaxOwnerDepth int
+	// OwnerLevel is the level of the owner chain to act on, counting up from the pod's controller (1), or 0
+	// for the top-level owner
+	OwnerLevel int
 }
 
+// DefaultMaxOwnerDepth is deep enough for any real owner chain, while still stopping on ownership cycles.
+const DefaultMaxOwnerDepth = 10
+
 // New creates a new Finder instance.
-func New(clientset *kubernetes.Clientset, log *logger.Logger) Finder {
+func New(clients kube.Clients, log *logger.Logger, opts Options) Finder {
+	maxOwnerDepth := opts.MaxOwnerDepth
+	if maxOwnerDepth <= 0 {
+		maxOwnerDepth = DefaultMaxOwnerDepth
+	}
 	return Finder{
-		clientset: clientset,
-		log:       log,
+		clientset:     clients.Kubernetes,
+		dynamic:       clients.Dynamic,
+		discovery:     clients.Discovery,
+		mapper:        clients.Mapper,
+		log:           log,
+		parallelism:   max(opts.Parallelism, 1),
+		paging:        opts.Paging,
+		maxOwnerDepth: maxOwnerDepth,
+		ownerLevel:    opts.OwnerLevel,
 	}
 }
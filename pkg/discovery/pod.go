@@ -3,37 +3,135 @@ package discovery
 import (
 	"context"
 	"fmt"
-	"maps"
-	"slices"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
 )
 
-// FindPodsUsingPVCs finds all pods that are using the given PVCs.
-// Returns a deduplicated list of pods.
-func (f *Finder) FindPodsUsingPVCs(ctx context.Context, pvcsPerNs map[string][]string) ([]corev1.Pod, error) {
-	pods := make(map[string]corev1.Pod) // key: namespace/name
+// claimIndex indexes pods by the PVCs they mount, keyed by "namespace/claimName".
+const claimIndex = "claim"
 
-	for ns, pvcs := range pvcsPerNs {
-		podList, err := f.clientset.CoreV1().Pods(ns).List(ctx, metav1.ListOptions{})
-		if err != nil {
-			return nil, fmt.Errorf("failed to list pods: %w", err)
+// PodIndex is a cache of pods, indexed by the PVCs they mount. It's kept up to date by a watch, so it can be
+// queried repeatedly without listing pods again.
+type PodIndex struct {
+	indexer cache.Indexer
+	changed chan struct{}
+}
+
+// IndexPods starts watching pods in the namespaces of the given PVCs (or in every namespace if there's more
+// than one), and returns once the initial list has been cached. The watch stops when the context is done.
+func (f *Finder) IndexPods(ctx context.Context, pvcsPerNs map[string][]string) (*PodIndex, error) {
+	opts := []informers.SharedInformerOption{
+		informers.WithTransform(stripManagedFields),
+		informers.WithTweakListOptions(f.tweakListOptions),
+	}
+	if len(pvcsPerNs) == 1 {
+		for ns := range pvcsPerNs {
+			opts = append(opts, informers.WithNamespace(ns))
 		}
+	}
+	factory := informers.NewSharedInformerFactoryWithOptions(f.clientset, 0, opts...)
+	informer := factory.Core().V1().Pods().Informer()
+	if err := informer.AddIndexers(cache.Indexers{claimIndex: podClaims}); err != nil {
+		return nil, fmt.Errorf("failed to index pods: %w", err)
+	}
+
+	index := &PodIndex{indexer: informer.GetIndexer(), changed: make(chan struct{}, 1)}
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(any) { index.notify() },
+		UpdateFunc: func(any, any) { index.notify() },
+		DeleteFunc: func(any) { index.notify() },
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch pods: %w", err)
+	}
 
-		for _, pod := range podList.Items {
-			if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
-				continue
+	factory.Start(ctx.Done())
+	for _, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
 			}
-			for _, vol := range pod.Spec.Volumes {
-				if vol.PersistentVolumeClaim != nil && slices.Contains(pvcs, vol.PersistentVolumeClaim.ClaimName) {
-					key := fmt.Sprintf("%s/%s", pod.Namespace, pod.Name)
-					pods[key] = pod
-					break // Found a matching PVC, no need to check other volumes
+			return nil, fmt.Errorf("failed to list pods")
+		}
+	}
+	return index, nil
+}
+
+// FindPodsUsingPVCs finds all pods that are using the given PVCs.
+// Returns a deduplicated list of pods.
+func (i *PodIndex) FindPodsUsingPVCs(pvcsPerNs map[string][]string) []corev1.Pod {
+	seen := make(map[string]bool) // key: namespace/name
+	var pods []corev1.Pod
+
+	for ns, pvcs := range pvcsPerNs {
+		for _, pvc := range pvcs {
+			objs, _ := i.indexer.ByIndex(claimIndex, ns+"/"+pvc)
+			for _, obj := range objs {
+				pod := obj.(*corev1.Pod)
+				if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+					continue
+				}
+				key := fmt.Sprintf("%s/%s", pod.Namespace, pod.Name)
+				if !seen[key] {
+					seen[key] = true
+					pods = append(pods, *pod)
 				}
 			}
 		}
 	}
 
-	return slices.Collect(maps.Values(pods)), nil
+	return pods
+}
+
+// Changed receives a value whenever a watched pod is added, updated or deleted. Notifications are
+// coalesced, so it's only a hint to check the index again.
+func (i *PodIndex) Changed() <-chan struct{} {
+	return i.changed
+}
+
+func (i *PodIndex) notify() {
+	select {
+	case i.changed <- struct{}{}:
+	default:
+	}
+}
+
+// tweakListOptions applies the configured paging to an index's initial list. Informers read from the watch
+// cache by default, so the list is made consistent unless reading from the cache was requested.
+func (f *Finder) tweakListOptions(opts *metav1.ListOptions) {
+	if opts.Watch {
+		return
+	}
+	if f.paging.PageSize > 0 {
+		opts.Limit = f.paging.PageSize
+	}
+	if !f.paging.FromCache && opts.ResourceVersion == "0" {
+		opts.ResourceVersion = ""
+	}
+}
+
+func podClaims(obj any) ([]string, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return nil, nil
+	}
+	var claims []string
+	for _, vol := range pod.Spec.Volumes {
+		if vol.PersistentVolumeClaim != nil {
+			claims = append(claims, pod.Namespace+"/"+vol.PersistentVolumeClaim.ClaimName)
+		}
+	}
+	return claims, nil
+}
+
+// stripManagedFields drops managed fields from cached objects, which are never used and take up a lot of memory.
+func stripManagedFields(obj any) (any, error) {
+	if accessor, err := meta.Accessor(obj); err == nil {
+		accessor.SetManagedFields(nil)
+	}
+	return obj, nil
 }
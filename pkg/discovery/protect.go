@@ -0,0 +1,58 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ProtectedPVC is a matched PVC which has opted out of being unmounted, either with the protect annotation or
+// by being in a protected namespace.
+type ProtectedPVC struct {
+	Namespace string
+	Name      string
+	// InProtectedNamespace is set if the PVC's namespace is protected, whether or not it's annotated
+	InProtectedNamespace bool
+}
+
+// protection returns whether the PVC is protected, by its namespace or its annotation.
+func protection(pvc metav1.Object, protectedNamespaces []string) (ProtectedPVC, bool) {
+	protected := ProtectedPVC{Namespace: pvc.GetNamespace(), Name: pvc.GetName()}
+	if slices.Contains(protectedNamespaces, pvc.GetNamespace()) {
+		protected.InProtectedNamespace = true
+		return protected, true
+	}
+	return protected, common.IsProtected(pvc)
+}
+
+// FindProtectedPVCs returns the given PVCs which are protected, for PVCs which were found without listing them.
+// PVCs in protected namespaces are protected regardless of their annotations, so they aren't looked up.
+func (f *Finder) FindProtectedPVCs(ctx context.Context, pvcsPerNs map[string][]string,
+	protectedNamespaces []string) ([]ProtectedPVC, error) {
+	var protected []ProtectedPVC
+
+	for ns, pvcs := range pvcsPerNs {
+		for _, name := range pvcs {
+			if slices.Contains(protectedNamespaces, ns) {
+				protected = append(protected, ProtectedPVC{Namespace: ns, Name: name, InProtectedNamespace: true})
+				continue
+			}
+			pvc, err := f.clientset.CoreV1().PersistentVolumeClaims(ns).Get(ctx, name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to get persistent volume claim %s/%s: %w", ns, name, err)
+			}
+			if p, ok := protection(pvc, protectedNamespaces); ok {
+				protected = append(protected, p)
+			}
+		}
+	}
+
+	return protected, nil
+}
@@ -0,0 +1,68 @@
+package discovery
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	"github.com/dancavallaro/kubectl-unmount/pkg/kube/kubetest"
+	"github.com/dancavallaro/kubectl-unmount/pkg/logger"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newProtectTestPVC(namespace, name string, protected bool) *corev1.PersistentVolumeClaim {
+	pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+	if protected {
+		pvc.Annotations = map[string]string{common.AnnotationProtect: "true"}
+	}
+	return pvc
+}
+
+func TestFindPVCsProtected(t *testing.T) {
+	clients := kubetest.NewClients(
+		newProtectTestPVC("ns-a", "data", false),
+		newProtectTestPVC("ns-a", "annotated", true),
+		newProtectTestPVC("kube-system", "etcd", false),
+	)
+	finder := New(clients.Clients, logger.NewLogger(io.Discard), Options{})
+
+	pvcsPerNs, protected, err := finder.FindPVCs(context.Background(), PVCFilter{}, []string{"kube-system"})
+	require.NoError(t, err)
+	require.Len(t, pvcsPerNs, 2)
+	require.ElementsMatch(t, []string{"data", "annotated"}, pvcsPerNs["ns-a"])
+	require.Equal(t, []string{"etcd"}, pvcsPerNs["kube-system"])
+	require.ElementsMatch(t, []ProtectedPVC{
+		{Namespace: "ns-a", Name: "annotated"},
+		{Namespace: "kube-system", Name: "etcd", InProtectedNamespace: true},
+	}, protected)
+
+	for _, action := range clients.FakeClientset.Actions() {
+		require.NotEqual(t, "get", action.GetVerb(), "%s %s", action.GetVerb(), action.GetResource().Resource)
+	}
+}
+
+func TestFindProtectedPVCs(t *testing.T) {
+	clients := kubetest.NewClients(
+		newProtectTestPVC("ns-a", "data", false),
+		newProtectTestPVC("ns-a", "annotated", true),
+		newProtectTestPVC("kube-system", "etcd", false),
+	)
+	finder := New(clients.Clients, logger.NewLogger(io.Discard), Options{})
+
+	protected, err := finder.FindProtectedPVCs(context.Background(), map[string][]string{
+		"ns-a":        {"data", "annotated", "missing"},
+		"kube-system": {"etcd"},
+	}, []string{"kube-system"})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []ProtectedPVC{
+		{Namespace: "ns-a", Name: "annotated"},
+		{Namespace: "kube-system", Name: "etcd", InProtectedNamespace: true},
+	}, protected)
+
+	for _, action := range clients.FakeClientset.Actions() {
+		require.NotEqual(t, "kube-system", action.GetNamespace(), "protected namespace was looked up")
+	}
+}
@@ -0,0 +1,79 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/kube"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// FindPVCsForVolumes resolves PersistentVolumes, given by name or by CSI volume handle, to the PVCs they're
+// bound to through spec.claimRef. Returns a map from namespace to list of PVC names.
+func (f *Finder) FindPVCsForVolumes(ctx context.Context, pvNames, volumeHandles []string) (map[string][]string, error) {
+	pvcsPerNs := make(map[string][]string)
+	found := make(map[string]bool) // key: PV name or volume handle
+
+	err := f.eachPersistentVolume(ctx, func(pv *corev1.PersistentVolume) error {
+		var key string
+		switch {
+		case slices.Contains(pvNames, pv.Name):
+			key = pv.Name
+		case pv.Spec.CSI != nil && slices.Contains(volumeHandles, pv.Spec.CSI.VolumeHandle):
+			key = pv.Spec.CSI.VolumeHandle
+		default:
+			return nil
+		}
+		found[key] = true
+
+		claim, ok := boundClaim(*pv)
+		if !ok {
+			f.log.Warn("PersistentVolume %s is not bound to a PVC, skipping", pv.Name)
+			return nil
+		}
+		if !slices.Contains(pvcsPerNs[claim.Namespace], claim.Name) {
+			pvcsPerNs[claim.Namespace] = append(pvcsPerNs[claim.Namespace], claim.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range pvNames {
+		if !found[name] {
+			return nil, fmt.Errorf("persistent volume %q not found", name)
+		}
+	}
+	for _, handle := range volumeHandles {
+		if !found[handle] {
+			return nil, fmt.Errorf("no persistent volume found with volume handle %q", handle)
+		}
+	}
+
+	return pvcsPerNs, nil
+}
+
+// eachPersistentVolume pages through every PV in the cluster.
+func (f *Finder) eachPersistentVolume(ctx context.Context, fn func(pv *corev1.PersistentVolume) error) error {
+	pvs := f.clientset.CoreV1().PersistentVolumes()
+	err := kube.EachListItem(ctx, f.paging, metav1.ListOptions{},
+		func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+			return pvs.List(ctx, opts)
+		}, fn)
+	if err != nil {
+		return fmt.Errorf("failed to list persistent volumes: %w", err)
+	}
+	return nil
+}
+
+// boundClaim returns the PVC a PV is bound to, if any.
+func boundClaim(pv corev1.PersistentVolume) (*corev1.ObjectReference, bool) {
+	if pv.Spec.ClaimRef == nil || pv.Status.Phase != corev1.VolumeBound {
+		return nil, false
+	}
+	return pv.Spec.ClaimRef, true
+}
@@ -3,34 +3,88 @@ package discovery
 import (
 	"context"
 	"fmt"
+	"slices"
 
+	"github.com/dancavallaro/kubectl-unmount/pkg/kube"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
-// PVCFilter contains criteria for filtering PVCs during discovery.
+// PVCFilter contains criteria for filtering PVCs during discovery. Empty fields match everything.
 type PVCFilter struct {
 	Namespace    string
 	StorageClass string
+	// Selector is a label selector, which is evaluated server-side
+	Selector string
+	// AccessModes matches PVCs with any of the given access modes
+	AccessModes []corev1.PersistentVolumeAccessMode
+	// CSIDriver matches PVCs bound to a PV provisioned by the given CSI driver
+	CSIDriver   string
+	Phase       corev1.PersistentVolumeClaimPhase
+	MinCapacity *resource.Quantity
+	MaxCapacity *resource.Quantity
 }
 
 // FindPVCs discovers all PVCs that match the given filters.
-// Returns a map from namespace to list of PVC names.
-func (f *Finder) FindPVCs(ctx context.Context, filter PVCFilter) (map[string][]string, error) {
+// Returns a map from namespace to list of PVC names, along with the matched PVCs which are protected (which
+// are still included in the map). Protection is checked as the PVCs are listed, so they aren't looked up again.
+func (f *Finder) FindPVCs(ctx context.Context, filter PVCFilter, protectedNamespaces []string) (map[string][]string,
+	[]ProtectedPVC, error) {
 	pvcsPerNs := make(map[string][]string)
+	var protected []ProtectedPVC
 
-	pvcList, err := f.clientset.CoreV1().PersistentVolumeClaims(filter.Namespace).List(ctx, metav1.ListOptions{})
+	var csiDrivers map[string]string
+	if filter.CSIDriver != "" {
+		var err error
+		if csiDrivers, err = f.findCSIDrivers(ctx); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	pvcs := f.clientset.CoreV1().PersistentVolumeClaims(filter.Namespace)
+	err := kube.EachListItem(ctx, f.paging, metav1.ListOptions{LabelSelector: filter.Selector},
+		func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+			return pvcs.List(ctx, opts)
+		}, func(pvc *corev1.PersistentVolumeClaim) error {
+			if !matchesStorageClass(pvc.Spec.StorageClassName, filter.StorageClass) ||
+				!matchesAccessModes(pvc.Spec.AccessModes, filter.AccessModes) ||
+				!matchesCapacity(pvc.Spec.Resources.Requests, filter.MinCapacity, filter.MaxCapacity) {
+				return nil
+			}
+			if filter.Phase != "" && pvc.Status.Phase != filter.Phase {
+				return nil
+			}
+			if filter.CSIDriver != "" && csiDrivers[pvc.Spec.VolumeName] != filter.CSIDriver {
+				return nil
+			}
+			pvcsPerNs[pvc.Namespace] = append(pvcsPerNs[pvc.Namespace], pvc.Name)
+			if p, ok := protection(pvc, protectedNamespaces); ok {
+				protected = append(protected, p)
+			}
+			return nil
+		})
 	if err != nil {
-		return nil, fmt.Errorf("failed to list persistent volume claims: %w", err)
+		return nil, nil, fmt.Errorf("failed to list persistent volume claims: %w", err)
 	}
 
-	for _, pvc := range pvcList.Items {
-		if !matchesStorageClass(pvc.Spec.StorageClassName, filter.StorageClass) {
-			continue
+	return pvcsPerNs, protected, nil
+}
+
+// findCSIDrivers returns a map from PV name to the CSI driver which provisioned it.
+func (f *Finder) findCSIDrivers(ctx context.Context) (map[string]string, error) {
+	drivers := make(map[string]string)
+	err := f.eachPersistentVolume(ctx, func(pv *corev1.PersistentVolume) error {
+		if pv.Spec.CSI != nil {
+			drivers[pv.Name] = pv.Spec.CSI.Driver
 		}
-		pvcsPerNs[pvc.Namespace] = append(pvcsPerNs[pvc.Namespace], pvc.Name)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-
-	return pvcsPerNs, nil
+	return drivers, nil
 }
 
 func matchesStorageClass(storageClassName *string, filter string) bool {
@@ -42,3 +96,32 @@ func matchesStorageClass(storageClassName *string, filter string) bool {
 	}
 	return *storageClassName == filter
 }
+
+func matchesAccessModes(accessModes []corev1.PersistentVolumeAccessMode, filter []corev1.PersistentVolumeAccessMode) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, mode := range accessModes {
+		if slices.Contains(filter, mode) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesCapacity(requests corev1.ResourceList, minCapacity, maxCapacity *resource.Quantity) bool {
+	if minCapacity == nil && maxCapacity == nil {
+		return true
+	}
+	storage, ok := requests[corev1.ResourceStorage]
+	if !ok {
+		return false
+	}
+	if minCapacity != nil && storage.Cmp(*minCapacity) < 0 {
+		return false
+	}
+	if maxCapacity != nil && storage.Cmp(*maxCapacity) > 0 {
+		return false
+	}
+	return true
+}
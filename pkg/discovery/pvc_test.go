@@ -0,0 +1,144 @@
+package discovery
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+)
+
+func TestMatchesStorageClass(t *testing.T) {
+	tests := []struct {
+		name             string
+		storageClassName *string
+		filter           string
+		want             bool
+	}{
+		{name: "no filter", storageClassName: ptr.To("standard"), filter: "", want: true},
+		{name: "no filter or class", storageClassName: nil, filter: "", want: true},
+		{name: "same class", storageClassName: ptr.To("standard"), filter: "standard", want: true},
+		{name: "other class", storageClassName: ptr.To("fast"), filter: "standard", want: false},
+		{name: "no class", storageClassName: nil, filter: "standard", want: false},
+		{name: "empty class", storageClassName: ptr.To(""), filter: "standard", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, matchesStorageClass(tt.storageClassName, tt.filter))
+		})
+	}
+}
+
+type testPVC struct {
+	namespace, name, storageClass, volumeName string
+	capacity                                  string
+	accessMode                                corev1.PersistentVolumeAccessMode
+	phase                                     corev1.PersistentVolumeClaimPhase
+	labels                                    map[string]string
+}
+
+func (p testPVC) object() *corev1.PersistentVolumeClaim {
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: p.name, Namespace: p.namespace, Labels: p.labels},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{p.accessMode},
+			VolumeName:  p.volumeName,
+		},
+		Status: corev1.PersistentVolumeClaimStatus{Phase: p.phase},
+	}
+	if p.storageClass != "" {
+		pvc.Spec.StorageClassName = ptr.To(p.storageClass)
+	}
+	if p.capacity != "" {
+		pvc.Spec.Resources.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(p.capacity)}
+	}
+	return pvc
+}
+
+func TestFindPVCs(t *testing.T) {
+	objs := []runtime.Object{
+		testPVC{namespace: "ns-a", name: "standard-small", storageClass: "standard", volumeName: "pv-1", capacity: "1Gi",
+			accessMode: corev1.ReadWriteOnce, phase: corev1.ClaimBound, labels: map[string]string{"app": "db"}}.object(),
+		testPVC{namespace: "ns-a", name: "fast-large", storageClass: "fast", volumeName: "pv-2", capacity: "100Gi",
+			accessMode: corev1.ReadWriteMany, phase: corev1.ClaimBound}.object(),
+		testPVC{namespace: "ns-b", name: "standard-pending", storageClass: "standard", capacity: "10Gi",
+			accessMode: corev1.ReadWriteOnce, phase: corev1.ClaimPending}.object(),
+		testPVC{namespace: "ns-b", name: "no-class", accessMode: corev1.ReadWriteOnce, phase: corev1.ClaimBound}.object(),
+		&corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv-1"},
+			Spec: corev1.PersistentVolumeSpec{PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{Driver: "ebs.csi.aws.com", VolumeHandle: "vol-1"},
+			}},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		filter PVCFilter
+		want   map[string][]string
+	}{
+		{
+			name:   "no filter",
+			filter: PVCFilter{},
+			want: map[string][]string{
+				"ns-a": {"fast-large", "standard-small"},
+				"ns-b": {"no-class", "standard-pending"},
+			},
+		},
+		{
+			name:   "storage class",
+			filter: PVCFilter{StorageClass: "standard"},
+			want: map[string][]string{
+				"ns-a": {"standard-small"},
+				"ns-b": {"standard-pending"},
+			},
+		},
+		{
+			name:   "storage class and namespace",
+			filter: PVCFilter{Namespace: "ns-b", StorageClass: "standard"},
+			want:   map[string][]string{"ns-b": {"standard-pending"}},
+		},
+		{
+			name:   "unknown storage class",
+			filter: PVCFilter{StorageClass: "missing"},
+			want:   map[string][]string{},
+		},
+		{
+			name:   "access modes",
+			filter: PVCFilter{AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany, corev1.ReadOnlyMany}},
+			want:   map[string][]string{"ns-a": {"fast-large"}},
+		},
+		{
+			name:   "phase",
+			filter: PVCFilter{Phase: corev1.ClaimPending},
+			want:   map[string][]string{"ns-b": {"standard-pending"}},
+		},
+		{
+			name:   "capacity range",
+			filter: PVCFilter{MinCapacity: ptr.To(resource.MustParse("5Gi")), MaxCapacity: ptr.To(resource.MustParse("50Gi"))},
+			want:   map[string][]string{"ns-b": {"standard-pending"}},
+		},
+		{
+			name:   "CSI driver",
+			filter: PVCFilter{CSIDriver: "ebs.csi.aws.com"},
+			want:   map[string][]string{"ns-a": {"standard-small"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			finder := newTestFinder(objs...)
+			got, _, err := finder.FindPVCs(context.Background(), tt.filter, nil)
+			require.NoError(t, err)
+			require.Len(t, got, len(tt.want))
+			for ns, names := range tt.want {
+				require.ElementsMatch(t, names, got[ns], "namespace %s", ns)
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	"github.com/dancavallaro/kubectl-unmount/pkg/kube"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+)
+
+// FindScaledDownControllers finds controllers that were previously scaled down or suspended by this plugin,
+// and whose pod templates use any of the given PVCs.
+func (f *Finder) FindScaledDownControllers(ctx context.Context, pvcsPerNs map[string][]string) ([]common.ControllerRef, error) {
+	var controllers []common.ControllerRef
+
+	customResources, err := f.findScalableCustomResources()
+	if err != nil {
+		return nil, err
+	}
+
+	for ns, pvcs := range pvcsPerNs {
+		add := func(gvk schema.GroupVersionKind, meta metav1.Object) {
+			controllers = append(controllers, controllerRef(gvk, meta))
+		}
+
+		deployments := f.clientset.AppsV1().Deployments(ns)
+		err := kube.EachListItem(ctx, f.paging, metav1.ListOptions{},
+			func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+				return deployments.List(ctx, opts)
+			}, func(d *appsv1.Deployment) error {
+				if isScaledDown(d) && usesAnyPVC(d.Spec.Template.Spec.Volumes, pvcs) {
+					add(appsv1.SchemeGroupVersion.WithKind(common.KindDeployment), d)
+				}
+				return nil
+			})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list deployments: %w", err)
+		}
+
+		statefulSets := f.clientset.AppsV1().StatefulSets(ns)
+		err = kube.EachListItem(ctx, f.paging, metav1.ListOptions{},
+			func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+				return statefulSets.List(ctx, opts)
+			}, func(s *appsv1.StatefulSet) error {
+				if !isScaledDown(s) {
+					return nil
+				}
+				if usesAnyPVC(s.Spec.Template.Spec.Volumes, pvcs) ||
+					claimTemplatesUseAnyPVC(s.Spec.VolumeClaimTemplates, s.Name, pvcs) {
+					add(appsv1.SchemeGroupVersion.WithKind(common.KindStatefulSet), s)
+				}
+				return nil
+			})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list statefulsets: %w", err)
+		}
+
+		replicaSets := f.clientset.AppsV1().ReplicaSets(ns)
+		err = kube.EachListItem(ctx, f.paging, metav1.ListOptions{},
+			func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+				return replicaSets.List(ctx, opts)
+			}, func(rs *appsv1.ReplicaSet) error {
+				// ReplicaSets owned by a Deployment are never scaled down directly
+				if len(rs.OwnerReferences) > 0 {
+					return nil
+				}
+				if isScaledDown(rs) && usesAnyPVC(rs.Spec.Template.Spec.Volumes, pvcs) {
+					add(appsv1.SchemeGroupVersion.WithKind(common.KindReplicaSet), rs)
+				}
+				return nil
+			})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list replicasets: %w", err)
+		}
+
+		// ReplicationControllers are scaled down through the scale API like custom resources, but are in the
+		// core group, which findScalableCustomResources skips
+		replicationControllers := f.clientset.CoreV1().ReplicationControllers(ns)
+		err = kube.EachListItem(ctx, f.paging, metav1.ListOptions{},
+			func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+				return replicationControllers.List(ctx, opts)
+			}, func(rc *corev1.ReplicationController) error {
+				if isScaledDown(rc) && rc.Spec.Template != nil && usesAnyPVC(rc.Spec.Template.Spec.Volumes, pvcs) {
+					add(corev1.SchemeGroupVersion.WithKind(common.KindReplicationController), rc)
+				}
+				return nil
+			})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list replicationcontrollers: %w", err)
+		}
+
+		daemonSets := f.clientset.AppsV1().DaemonSets(ns)
+		err = kube.EachListItem(ctx, f.paging, metav1.ListOptions{},
+			func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+				return daemonSets.List(ctx, opts)
+			}, func(ds *appsv1.DaemonSet) error {
+				if isScaledDown(ds) && usesAnyPVC(ds.Spec.Template.Spec.Volumes, pvcs) {
+					add(appsv1.SchemeGroupVersion.WithKind(common.KindDaemonSet), ds)
+				}
+				return nil
+			})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list daemonsets: %w", err)
+		}
+
+		cronJobs := f.clientset.BatchV1().CronJobs(ns)
+		err = kube.EachListItem(ctx, f.paging, metav1.ListOptions{},
+			func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+				return cronJobs.List(ctx, opts)
+			}, func(cj *batchv1.CronJob) error {
+				if isScaledDown(cj) && usesAnyPVC(cj.Spec.JobTemplate.Spec.Template.Spec.Volumes, pvcs) {
+					add(batchv1.SchemeGroupVersion.WithKind(common.KindCronJob), cj)
+				}
+				return nil
+			})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list cronjobs: %w", err)
+		}
+
+		jobs := f.clientset.BatchV1().Jobs(ns)
+		err = kube.EachListItem(ctx, f.paging, metav1.ListOptions{},
+			func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+				return jobs.List(ctx, opts)
+			}, func(job *batchv1.Job) error {
+				if isScaledDown(job) && usesAnyPVC(job.Spec.Template.Spec.Volumes, pvcs) {
+					add(batchv1.SchemeGroupVersion.WithKind(common.KindJob), job)
+				}
+				return nil
+			})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list jobs: %w", err)
+		}
+
+		for _, res := range customResources {
+			resource := f.dynamic.Resource(res.gvr).Namespace(ns)
+			err = kube.EachListItem(ctx, f.paging, metav1.ListOptions{},
+				func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+					return resource.List(ctx, opts)
+				}, func(obj *unstructured.Unstructured) error {
+					if isScaledDown(obj) && templateUsesAnyPVC(*obj, pvcs) {
+						add(res.gvr.GroupVersion().WithKind(res.kind), obj)
+					}
+					return nil
+				})
+			if err != nil {
+				return nil, fmt.Errorf("failed to list %s: %w", res.gvr.GroupResource(), err)
+			}
+		}
+	}
+
+	return controllers, nil
+}
+
+func isScaledDown(meta metav1.Object) bool {
+	annotations := []string{
+		common.AnnotationOriginalReplicas,
+		common.AnnotationOriginalSuspend,
+		common.AnnotationOriginalTemplate,
+	}
+	for _, annotation := range annotations {
+		if _, ok := meta.GetAnnotations()[annotation]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func controllerRef(gvk schema.GroupVersionKind, meta metav1.Object) common.ControllerRef {
+	apiVersion, kind := gvk.ToAPIVersionAndKind()
+	return common.ControllerRef{
+		APIVersion: apiVersion,
+		Kind:       kind,
+		Namespace:  meta.GetNamespace(),
+		Name:       meta.GetName(),
+	}
+}
+
+type scalableResource struct {
+	gvr  schema.GroupVersionResource
+	kind string
+}
+
+// findScalableCustomResources finds the namespaced resources which have a scale subresource, other than the
+// built-in ones, using the preferred version of each API group.
+func (f *Finder) findScalableCustomResources() ([]scalableResource, error) {
+	groups, resourceLists, err := f.discovery.ServerGroupsAndResources()
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return nil, fmt.Errorf("failed to discover API resources: %w", err)
+	}
+
+	preferred := make(map[string]bool)
+	for _, group := range groups {
+		if group.Name != "" && group.Name != appsv1.GroupName {
+			preferred[group.PreferredVersion.GroupVersion] = true
+		}
+	}
+
+	var resources []scalableResource
+	for _, list := range resourceLists {
+		if !preferred[list.GroupVersion] {
+			continue
+		}
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, res := range list.APIResources {
+			if !res.Namespaced || strings.Contains(res.Name, "/") {
+				continue
+			}
+			hasScale := slices.ContainsFunc(list.APIResources, func(sub metav1.APIResource) bool {
+				return sub.Name == res.Name+"/scale"
+			})
+			if hasScale {
+				resources = append(resources, scalableResource{gvr: gv.WithResource(res.Name), kind: res.Kind})
+			}
+		}
+	}
+	return resources, nil
+}
+
+// templateUsesAnyPVC checks the pod template of a custom resource for any of the given PVCs. Most
+// controllers with a scale subresource (e.g. Argo Rollouts and OpenKruise CloneSets) have a pod template in
+// spec.template, just like a Deployment.
+func templateUsesAnyPVC(obj unstructured.Unstructured, pvcs []string) bool {
+	rawVolumes, found, err := unstructured.NestedSlice(obj.Object, "spec", "template", "spec", "volumes")
+	if err != nil || !found {
+		return false
+	}
+
+	var volumes []corev1.Volume
+	for _, raw := range rawVolumes {
+		rawVolume, ok := raw.(map[string]any)
+		if !ok {
+			continue
+		}
+		var vol corev1.Volume
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(rawVolume, &vol); err == nil {
+			volumes = append(volumes, vol)
+		}
+	}
+	return usesAnyPVC(volumes, pvcs)
+}
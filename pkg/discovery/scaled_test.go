@@ -0,0 +1,40 @@
+package discovery
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFindScaledDownControllers(t *testing.T) {
+	scaledDown := map[string]string{common.AnnotationOriginalReplicas: "3"}
+	template := corev1.PodTemplateSpec{Spec: corev1.PodSpec{Volumes: []corev1.Volume{{
+		Name: "data",
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "test-pvc"},
+		},
+	}}}}
+	meta := func(name string, annotations map[string]string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Name: name, Namespace: testNamespace, Annotations: annotations}
+	}
+
+	finder := newTestFinder(
+		&appsv1.Deployment{ObjectMeta: meta("scaled-down", scaledDown), Spec: appsv1.DeploymentSpec{Template: template}},
+		&appsv1.Deployment{ObjectMeta: meta("running", nil), Spec: appsv1.DeploymentSpec{Template: template}},
+		&appsv1.Deployment{ObjectMeta: meta("other-pvc", scaledDown)},
+		&corev1.ReplicationController{ObjectMeta: meta("scaled-down-rc", scaledDown), Spec: corev1.ReplicationControllerSpec{Template: &template}},
+		&corev1.ReplicationController{ObjectMeta: meta("no-template", scaledDown)},
+	)
+
+	controllers, err := finder.FindScaledDownControllers(context.Background(), map[string][]string{testNamespace: {"test-pvc"}})
+	require.NoError(t, err)
+	require.Equal(t, []common.ControllerRef{
+		{APIVersion: "apps/v1", Kind: common.KindDeployment, Namespace: testNamespace, Name: "scaled-down"},
+		{APIVersion: "v1", Kind: common.KindReplicationController, Namespace: testNamespace, Name: "scaled-down-rc"},
+	}, controllers)
+}
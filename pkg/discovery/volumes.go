@@ -0,0 +1,48 @@
+package discovery
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// usesAnyPVC returns true if any of the given volumes references one of the given PVCs.
+func usesAnyPVC(volumes []corev1.Volume, pvcs []string) bool {
+	for _, vol := range volumes {
+		if vol.PersistentVolumeClaim != nil && slices.Contains(pvcs, vol.PersistentVolumeClaim.ClaimName) {
+			return true
+		}
+	}
+	return false
+}
+
+// claimTemplatesUseAnyPVC returns true if any of the given PVCs was created from one of a StatefulSet's
+// volumeClaimTemplates. Such PVCs are named "<template>-<statefulset>-<ordinal>".
+func claimTemplatesUseAnyPVC(templates []corev1.PersistentVolumeClaim, statefulSetName string, pvcs []string) bool {
+	for _, tmpl := range templates {
+		prefix := tmpl.Name + "-" + statefulSetName + "-"
+		for _, pvc := range pvcs {
+			ordinal, found := strings.CutPrefix(pvc, prefix)
+			if !found {
+				continue
+			}
+			if _, err := strconv.Atoi(ordinal); err == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MountedPVCs returns the names of the given PVCs which are used by the pod.
+func MountedPVCs(pod corev1.Pod, pvcs []string) []string {
+	var mounted []string
+	for _, vol := range pod.Spec.Volumes {
+		if vol.PersistentVolumeClaim != nil && slices.Contains(pvcs, vol.PersistentVolumeClaim.ClaimName) {
+			mounted = append(mounted, vol.PersistentVolumeClaim.ClaimName)
+		}
+	}
+	return mounted
+}
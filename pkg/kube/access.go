@@ -0,0 +1,67 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Permission is an API request which needs to be allowed, e.g. updating deployments/scale in a namespace.
+type Permission struct {
+	Verb        string
+	Group       string
+	Resource    string
+	Subresource string
+	// Namespace is empty for cluster-scoped resources, or to check every namespace
+	Namespace string
+}
+
+// ResourceString returns the resource in kubectl's "resource.group/subresource" form.
+func (p Permission) ResourceString() string {
+	res := p.Resource
+	if p.Group != "" {
+		res += "." + p.Group
+	}
+	if p.Subresource != "" {
+		res += "/" + p.Subresource
+	}
+	return res
+}
+
+func (p Permission) String() string {
+	if p.Namespace == "" {
+		return fmt.Sprintf("%s %s", p.Verb, p.ResourceString())
+	}
+	return fmt.Sprintf("%s %s in %s", p.Verb, p.ResourceString(), p.Namespace)
+}
+
+// AccessResult is whether a permission is allowed for the current user.
+type AccessResult struct {
+	Permission
+	Allowed bool
+	// Reason is given by the authorizer, and is usually only set when the permission is denied
+	Reason string
+}
+
+// CheckAccess asks the API server whether the current user has the permission, with a SelfSubjectAccessReview.
+func CheckAccess(ctx context.Context, clientset kubernetes.Interface, perm Permission) (AccessResult, error) {
+	review := &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace:   perm.Namespace,
+				Verb:        perm.Verb,
+				Group:       perm.Group,
+				Resource:    perm.Resource,
+				Subresource: perm.Subresource,
+			},
+		},
+	}
+	review, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+	if err != nil {
+		return AccessResult{}, fmt.Errorf("failed to check access to %v: %w", perm, err)
+	}
+	return AccessResult{Permission: perm, Allowed: review.Status.Allowed, Reason: review.Status.Reason}, nil
+}
@@ -0,0 +1,65 @@
+package kube
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/scale"
+)
+
+// Clients bundles the Kubernetes clients used for discovery and scaling.
+type Clients struct {
+	Kubernetes kubernetes.Interface
+	// Dynamic is used for resources which aren't built in to Kubernetes, e.g. custom resources
+	Dynamic   dynamic.Interface
+	Discovery discovery.DiscoveryInterface
+	Mapper    meta.RESTMapper
+	// Scales is the polymorphic scale client, for any resource with a scale subresource
+	Scales scale.ScalesGetter
+}
+
+// NewClients creates all the clients from kubeconfig flags.
+func NewClients(getter genericclioptions.RESTClientGetter) (Clients, error) {
+	config, err := getter.ToRESTConfig()
+	if err != nil {
+		return Clients{}, fmt.Errorf("failed to read kubeconfig: %w", err)
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return Clients{}, fmt.Errorf("failed to create clientset: %w", err)
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return Clients{}, fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+
+	discoveryClient, err := getter.ToDiscoveryClient()
+	if err != nil {
+		return Clients{}, fmt.Errorf("failed to create discovery client: %w", err)
+	}
+
+	mapper, err := getter.ToRESTMapper()
+	if err != nil {
+		return Clients{}, fmt.Errorf("failed to create REST mapper: %w", err)
+	}
+
+	scales, err := scale.NewForConfig(config, mapper, dynamic.LegacyAPIPathResolverFunc,
+		scale.NewDiscoveryScaleKindResolver(discoveryClient))
+	if err != nil {
+		return Clients{}, fmt.Errorf("failed to create scale client: %w", err)
+	}
+
+	return Clients{
+		Kubernetes: clientset,
+		Dynamic:    dynamicClient,
+		Discovery:  discoveryClient,
+		Mapper:     mapper,
+		Scales:     scales,
+	}, nil
+}
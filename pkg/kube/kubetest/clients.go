@@ -0,0 +1,176 @@
+// Package kubetest provides fake Kubernetes clients for unit tests, so they can run without a cluster.
+package kubetest
+
+import (
+	"fmt"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/kube"
+	appsv1 "k8s.io/api/apps/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	"k8s.io/apimachinery/pkg/api/meta/testrestmapper"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	scalefake "k8s.io/client-go/scale/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// CustomResources are registered with the fake dynamic client and REST mapper, since the fake dynamic client
+// panics when listing resources it doesn't know about.
+var CustomResources = []schema.GroupVersionKind{
+	{Group: "keda.sh", Version: "v1alpha1", Kind: "ScaledObject"},
+	{Group: "postgresql.cnpg.io", Version: "v1", Kind: "Cluster"},
+	{Group: "kafka.strimzi.io", Version: "v1beta2", Kind: "Kafka"},
+	{Group: "core.strimzi.io", Version: "v1beta2", Kind: "StrimziPodSet"},
+	{Group: "acid.zalan.do", Version: "v1", Kind: "postgresql"},
+	{Group: "postgres-operator.crunchydata.com", Version: "v1beta1", Kind: "PostgresCluster"},
+	{Group: "kubevirt.io", Version: "v1", Kind: "VirtualMachine"},
+	{Group: "kubevirt.io", Version: "v1", Kind: "VirtualMachineInstance"},
+	{Group: "argoproj.io", Version: "v1alpha1", Kind: "Application"},
+	// An arbitrary custom resource, for handler rules
+	{Group: "example.com", Version: "v1", Kind: "Widget"},
+	// A custom resource of the same kind as a built-in one
+	{Group: "example.com", Version: "v1", Kind: "Deployment"},
+}
+
+// Clients bundles fake clients, which are also exposed as their fakes so tests can add reactors and
+// inspect the actions taken.
+type Clients struct {
+	kube.Clients
+
+	FakeClientset *fake.Clientset
+	FakeDynamic   *dynamicfake.FakeDynamicClient
+	FakeScales    *scalefake.FakeScaleClient
+}
+
+// NewClients creates fake clients seeded with the given objects. The typed and dynamic clients each have
+// their own tracker, so changes made through one aren't seen by the other. Unstructured objects (i.e. custom
+// resources) are only added to the dynamic client.
+//
+// The typed client also handles the scale subresource of Deployments, StatefulSets and ReplicaSets, which
+// the fake tracker doesn't support, and allows every SelfSubjectAccessReview.
+func NewClients(objs ...runtime.Object) Clients {
+	var typed []runtime.Object
+	for _, obj := range objs {
+		if _, ok := obj.(*unstructured.Unstructured); !ok {
+			typed = append(typed, obj)
+		}
+	}
+	clientset := fake.NewClientset(typed...)
+	clientset.PrependReactor("*", "*", scaleReactor(clientset.Tracker()))
+	clientset.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		review.Status.Allowed = true
+		return true, review, nil
+	})
+
+	testScheme := newScheme()
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(testScheme, objs...)
+	scales := &scalefake.FakeScaleClient{}
+
+	return Clients{
+		Clients: kube.Clients{
+			Kubernetes: clientset,
+			Dynamic:    dynamicClient,
+			Discovery:  clientset.Discovery(),
+			Mapper:     testrestmapper.TestOnlyStaticRESTMapper(testScheme),
+			Scales:     scales,
+		},
+		FakeClientset: clientset,
+		FakeDynamic:   dynamicClient,
+		FakeScales:    scales,
+	}
+}
+
+// newScheme returns the built-in types, plus CustomResources as unstructured objects.
+func newScheme() *runtime.Scheme {
+	s := runtime.NewScheme()
+	utilruntime.Must(scheme.AddToScheme(s))
+	for _, gvk := range CustomResources {
+		s.AddKnownTypeWithName(gvk, &unstructured.Unstructured{})
+		s.AddKnownTypeWithName(gvk.GroupVersion().WithKind(gvk.Kind+"List"), &unstructured.UnstructuredList{})
+	}
+	return s
+}
+
+// scaleReactor gets and updates the scale subresource from the replica count of the object in the tracker.
+func scaleReactor(tracker k8stesting.ObjectTracker) k8stesting.ReactionFunc {
+	return func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "scale" {
+			return false, nil, nil
+		}
+		gvr := action.GetResource()
+		ns := action.GetNamespace()
+
+		switch action := action.(type) {
+		case k8stesting.GetAction:
+			obj, err := tracker.Get(gvr, ns, action.GetName())
+			if err != nil {
+				return true, nil, err
+			}
+			replicas, err := replicasOf(obj)
+			if err != nil {
+				return true, nil, err
+			}
+			return true, &autoscalingv1.Scale{
+				ObjectMeta: metav1.ObjectMeta{Name: action.GetName(), Namespace: ns},
+				Spec:       autoscalingv1.ScaleSpec{Replicas: replicas},
+				Status:     autoscalingv1.ScaleStatus{Replicas: replicas},
+			}, nil
+		case k8stesting.UpdateAction:
+			scale := action.GetObject().(*autoscalingv1.Scale)
+			obj, err := tracker.Get(gvr, ns, scale.Name)
+			if err != nil {
+				return true, nil, err
+			}
+			if err := setReplicas(obj, scale.Spec.Replicas); err != nil {
+				return true, nil, err
+			}
+			if err := tracker.Update(gvr, obj, ns); err != nil {
+				return true, nil, err
+			}
+			return true, scale, nil
+		default:
+			return false, nil, nil
+		}
+	}
+}
+
+func replicasOf(obj runtime.Object) (int32, error) {
+	var replicas *int32
+	switch obj := obj.(type) {
+	case *appsv1.Deployment:
+		replicas = obj.Spec.Replicas
+	case *appsv1.StatefulSet:
+		replicas = obj.Spec.Replicas
+	case *appsv1.ReplicaSet:
+		replicas = obj.Spec.Replicas
+	default:
+		return 0, fmt.Errorf("%T has no scale subresource", obj)
+	}
+	if replicas == nil {
+		// The API server defaults replicas to 1
+		return 1, nil
+	}
+	return *replicas, nil
+}
+
+func setReplicas(obj runtime.Object, replicas int32) error {
+	switch obj := obj.(type) {
+	case *appsv1.Deployment:
+		obj.Spec.Replicas = &replicas
+	case *appsv1.StatefulSet:
+		obj.Spec.Replicas = &replicas
+	case *appsv1.ReplicaSet:
+		obj.Spec.Replicas = &replicas
+	default:
+		return fmt.Errorf("%T has no scale subresource", obj)
+	}
+	return nil
+}
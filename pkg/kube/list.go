@@ -0,0 +1,40 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/pager"
+)
+
+// Paging configures how list calls page through results.
+type Paging struct {
+	// PageSize is the number of items requested per page, or 0 for the client default (500)
+	PageSize int64
+	// FromCache reads lists from the API server's watch cache (resourceVersion=0), which is cheaper for the
+	// API server but may be slightly stale
+	FromCache bool
+}
+
+// EachListItem pages through a list, calling fn on each item as its page is received, so the whole list is
+// never held in memory at once.
+func EachListItem[T runtime.Object](ctx context.Context, paging Paging, opts metav1.ListOptions,
+	list pager.ListPageFunc, fn func(T) error) error {
+	p := pager.New(list)
+	if paging.PageSize > 0 {
+		p.PageSize = paging.PageSize
+	}
+	if paging.FromCache {
+		opts.ResourceVersion = "0"
+	}
+
+	return p.EachListItem(ctx, opts, func(obj runtime.Object) error {
+		item, ok := obj.(T)
+		if !ok {
+			return fmt.Errorf("unexpected list item type %T", obj)
+		}
+		return fn(item)
+	})
+}
@@ -1,14 +1,20 @@
 package logger
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"sync"
 
 	"github.com/fatih/color"
 )
 
 type Logger struct {
-	w io.Writer
+	mu sync.Mutex
+	w  io.Writer
+
+	// parent is set for buffered loggers, and receives their messages when flushed
+	parent *Logger
 }
 
 func NewLogger(w io.Writer) *Logger {
@@ -33,6 +39,25 @@ func (l *Logger) Instructions(msg string, args ...any) {
 	l.log(color.FgHiWhite, "\n"+msg, args...)
 }
 
+// Buffer returns a logger which holds its messages until Flush is called, so that concurrent operations can
+// be logged in a deterministic order.
+func (l *Logger) Buffer() *Logger {
+	return &Logger{w: &bytes.Buffer{}, parent: l}
+}
+
+// Flush writes the messages held by a buffered logger to its parent.
+func (l *Logger) Flush() {
+	buf, ok := l.w.(*bytes.Buffer)
+	if !ok || l.parent == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.parent.write(buf.Bytes())
+	buf.Reset()
+}
+
 func (l *Logger) log(col color.Attribute, msg string, args ...any) {
 	if msg == "" {
 		fmt.Println("")
@@ -40,5 +65,11 @@ func (l *Logger) log(col color.Attribute, msg string, args ...any) {
 	}
 
 	c := color.New(col)
-	_, _ = c.Fprint(l.w, fmt.Sprintf(msg, args...))
+	l.write([]byte(c.Sprint(fmt.Sprintf(msg, args...))))
+}
+
+func (l *Logger) write(p []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = l.w.Write(p)
 }
@@ -0,0 +1,138 @@
+package plugin
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/resource"
+)
+
+// pvcAliases are the names by which a PVC can be referred to in "type/name" arguments.
+var pvcAliases = []string{"pvc", "persistentvolumeclaim", "persistentvolumeclaims"}
+
+// hasPVCArgs returns true if PVCs were selected explicitly with positional arguments or files.
+func (cfg *ConfigFlags) hasPVCArgs() bool {
+	return len(cfg.PVCArgs) > 0 || (cfg.Filenames != nil && len(cfg.Filenames.Filenames) > 0)
+}
+
+// hasVolumeSelectors returns true if PVCs were selected by the PersistentVolumes they're bound to.
+func (cfg *ConfigFlags) hasVolumeSelectors() bool {
+	return len(stringSlice(cfg.PVNames)) > 0 || len(stringSlice(cfg.VolumeHandles)) > 0
+}
+
+func stringSlice(val *[]string) []string {
+	if val == nil {
+		return nil
+	}
+	return *val
+}
+
+// resolvePVCArgs resolves the PVCs selected by positional arguments and files, grouped by namespace.
+// Arguments are anything kubectl accepts (e.g. "pvc/a pvc/b" or "pvc a b"), plus "namespace/name" pairs
+// for selecting PVCs across namespaces.
+func (cfg *ConfigFlags) resolvePVCArgs() (map[string][]string, error) {
+	mapper, err := cfg.ToRESTMapper()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create REST mapper: %w", err)
+	}
+	pvcsPerNs, builderArgs, err := splitPVCArgs(cfg.PVCArgs, mapper)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(builderArgs) == 0 && (cfg.Filenames == nil || len(cfg.Filenames.Filenames) == 0) {
+		return pvcsPerNs, nil
+	}
+
+	namespace, enforceNamespace, err := cfg.ToRawKubeConfigLoader().Namespace()
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine namespace: %w", err)
+	}
+
+	builder := resource.NewBuilder(&cfg.ConfigFlags).
+		Unstructured().
+		NamespaceParam(namespace).DefaultNamespace().
+		Flatten()
+	if cfg.Filenames != nil {
+		builder = builder.FilenameParam(enforceNamespace, cfg.Filenames)
+	}
+	if len(builderArgs) > 0 {
+		builder = builder.ResourceTypeOrNameArgs(false, builderArgs...)
+	}
+
+	err = builder.Do().Visit(func(info *resource.Info, err error) error {
+		if err != nil {
+			return err
+		}
+		if gvk := info.Mapping.GroupVersionKind; gvk.Group != "" || gvk.Kind != common.KindPersistentVolumeClaim {
+			return fmt.Errorf("%s %q is not a PersistentVolumeClaim", info.Mapping.GroupVersionKind.Kind, info.Name)
+		}
+		pvcsPerNs[info.Namespace] = appendUnique(pvcsPerNs[info.Namespace], info.Name)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return pvcsPerNs, nil
+}
+
+// splitPVCArgs picks out the "namespace/name" arguments, grouped by namespace, from the ones to pass to the
+// resource builder. An argument whose prefix is a resource type is left to the builder, which rejects
+// anything but PVCs, so e.g. "deployment/foo" isn't taken to be PVC foo in namespace "deployment". PVCs in
+// a namespace named after a resource type are selected with --namespace instead.
+func splitPVCArgs(args []string, mapper meta.RESTMapper) (map[string][]string, []string, error) {
+	pvcsPerNs := make(map[string][]string)
+	var builderArgs []string
+	for _, arg := range args {
+		ns, name, found := strings.Cut(arg, "/")
+		if !found || isPVCAlias(ns) {
+			builderArgs = append(builderArgs, arg)
+			continue
+		}
+		isType, err := isResourceType(mapper, ns)
+		if err != nil {
+			return nil, nil, err
+		}
+		if isType {
+			builderArgs = append(builderArgs, arg)
+			continue
+		}
+		if name == "" || strings.Contains(name, "/") {
+			return nil, nil, fmt.Errorf("invalid argument %q, must be namespace/name or type/name", arg)
+		}
+		pvcsPerNs[ns] = appendUnique(pvcsPerNs[ns], name)
+	}
+	return pvcsPerNs, builderArgs, nil
+}
+
+// isResourceType returns true if the cluster serves a resource of the given type, e.g. "deployments",
+// "deployment" or "deployments.apps".
+func isResourceType(mapper meta.RESTMapper, resourceType string) (bool, error) {
+	gr := schema.ParseGroupResource(strings.ToLower(resourceType))
+	_, err := mapper.ResourceFor(gr.WithVersion(""))
+	if meta.IsNoMatchError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to look up resource type %q: %w", resourceType, err)
+	}
+	return true, nil
+}
+
+func isPVCAlias(resourceType string) bool {
+	// Allow fully qualified types, e.g. "persistentvolumeclaims.v1."
+	resourceType, _, _ = strings.Cut(strings.ToLower(resourceType), ".")
+	return slices.Contains(pvcAliases, resourceType)
+}
+
+func appendUnique(names []string, name string) []string {
+	if slices.Contains(names, name) {
+		return names
+	}
+	return append(names, name)
+}
@@ -0,0 +1,83 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/kube/kubetest"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/restmapper"
+)
+
+func TestSplitPVCArgs(t *testing.T) {
+	tests := []struct {
+		name            string
+		args            []string
+		wantPVCsPerNs   map[string][]string
+		wantBuilderArgs []string
+		wantErr         string
+	}{
+		{
+			name:          "namespace/name pairs",
+			args:          []string{"ns-a/data", "ns-b/data", "ns-a/data", "ns-a/logs"},
+			wantPVCsPerNs: map[string][]string{"ns-a": {"data", "logs"}, "ns-b": {"data"}},
+		},
+		{
+			name:            "PVC aliases",
+			args:            []string{"pvc/a", "PersistentVolumeClaim/b", "persistentvolumeclaims.v1./c"},
+			wantPVCsPerNs:   map[string][]string{},
+			wantBuilderArgs: []string{"pvc/a", "PersistentVolumeClaim/b", "persistentvolumeclaims.v1./c"},
+		},
+		{
+			name:            "type and names",
+			args:            []string{"pvc", "a", "b"},
+			wantPVCsPerNs:   map[string][]string{},
+			wantBuilderArgs: []string{"pvc", "a", "b"},
+		},
+		{
+			name:            "other resource types are left to the builder",
+			args:            []string{"deployment/foo", "deployments.apps/foo", "pv/foo", "persistentvolumes/foo"},
+			wantPVCsPerNs:   map[string][]string{},
+			wantBuilderArgs: []string{"deployment/foo", "deployments.apps/foo", "pv/foo", "persistentvolumes/foo"},
+		},
+		{
+			name:            "mixed",
+			args:            []string{"pvc/a", "ns-a/data", "statefulset/db"},
+			wantPVCsPerNs:   map[string][]string{"ns-a": {"data"}},
+			wantBuilderArgs: []string{"pvc/a", "statefulset/db"},
+		},
+		{
+			name:    "missing name",
+			args:    []string{"ns-a/"},
+			wantErr: `invalid argument "ns-a/", must be namespace/name or type/name`,
+		},
+		{
+			name:    "too many parts",
+			args:    []string{"ns-a/data/extra"},
+			wantErr: `invalid argument "ns-a/data/extra", must be namespace/name or type/name`,
+		},
+	}
+
+	// Short names like "pv" are expanded from discovery, like kubectl does
+	clients := kubetest.NewClients()
+	clients.FakeClientset.Resources = []*metav1.APIResourceList{{
+		GroupVersion: "v1",
+		APIResources: []metav1.APIResource{
+			{Name: "persistentvolumes", Kind: "PersistentVolume", ShortNames: []string{"pv"}},
+			{Name: "persistentvolumeclaims", Kind: "PersistentVolumeClaim", ShortNames: []string{"pvc"}},
+		},
+	}}
+	mapper := restmapper.NewShortcutExpander(clients.Mapper, clients.Discovery, nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pvcsPerNs, builderArgs, err := splitPVCArgs(tt.args, mapper)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantPVCsPerNs, pvcsPerNs)
+			require.Equal(t, tt.wantBuilderArgs, builderArgs)
+		})
+	}
+}
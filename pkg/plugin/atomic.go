@@ -0,0 +1,90 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/scaling"
+)
+
+// rollbackTimeout bounds rolling back with --atomic, which carries on after the run was interrupted or timed out.
+const rollbackTimeout = 2 * time.Minute
+
+func (cfg *ConfigFlags) isAtomic() bool {
+	return cfg.Atomic != nil && *cfg.Atomic
+}
+
+// abort handles a run failing after the cluster may have been modified. With --atomic, every controller of
+// the plan which was acted on is rolled back, and every GitOps owner suspended is resumed. Otherwise, the
+// suspended owners are resumed unless they reconcile a controller which was scaled down, in which case they
+// stay suspended until restore finds them through that controller. Either way the plan is printed, with the
+// outcome for each controller.
+func (cfg *ConfigFlags) abort(ctx context.Context, scaler scaling.Scaler, plan *Plan, suspended gitOpsOwners,
+	err error) error {
+	if cfg.isAtomic() {
+		cfg.logger.Error(fmt.Errorf("%w, rolling back", err))
+		if failed := rollback(ctx, cfg, scaler, plan.Controllers, suspended); failed > 0 {
+			cfg.logger.Warn("Rollback encountered %d errors, run restore to retry", failed)
+		} else {
+			cfg.logger.Info("Rollback complete")
+		}
+	} else if owners := unrestorableGitOps(plan.Controllers, suspended); len(owners) > 0 {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+		defer cancel()
+		cfg.logger.Info("Resuming %d GitOps owner(s) of controllers which weren't scaled down...", len(owners))
+		if failed := resumeGitOps(ctx, cfg, scaler, owners); failed > 0 {
+			cfg.logger.Warn("Resuming GitOps owners encountered %d errors", failed)
+		}
+	}
+
+	if printErr := cfg.printPlan(plan); printErr != nil {
+		cfg.logger.Error(printErr)
+	}
+	return err
+}
+
+// unrestorableGitOps returns the suspended GitOps owners which restore wouldn't find, since none of the
+// controllers they reconcile were scaled down.
+func unrestorableGitOps(controllers []PlanController, suspended gitOpsOwners) []scaling.GitOpsOwner {
+	var owners []scaling.GitOpsOwner
+	for _, owner := range suspended.Unique() {
+		scaledDown := slices.ContainsFunc(controllers, func(ctrl PlanController) bool {
+			return ctrl.Result == ResultSucceeded && slices.Contains(suspended[ctrl.ref()], owner)
+		})
+		if !scaledDown {
+			owners = append(owners, owner)
+		}
+	}
+	return owners
+}
+
+// rollback restores the given controllers which were acted on, in reverse order, and resumes the GitOps owners
+// suspended by this run. Returns the number of errors, which are also recorded in the plan.
+func rollback(ctx context.Context, cfg *ConfigFlags, scaler scaling.Scaler, controllers []PlanController,
+	suspended gitOpsOwners) int {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+	defer cancel()
+
+	errors := 0
+	for i := range slices.Backward(controllers) {
+		ctrl := &controllers[i]
+		if ctrl.Result == "" {
+			continue
+		}
+		if !scaler.Restorable(ctrl.ref()) {
+			cfg.logger.Warn("  %v was deleted and cannot be rolled back", ctrl.ref())
+			ctrl.Rollback = ResultNotRestorable
+			continue
+		}
+		err := scaler.Restore(ctx, ctrl.ref())
+		ctrl.setRollbackResult(cfg.isDryRun(), err)
+		if err != nil {
+			cfg.logger.Error(err)
+			errors++
+		}
+	}
+
+	return errors + resumeGitOps(ctx, cfg, scaler, suspended.Unique())
+}
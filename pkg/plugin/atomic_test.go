@@ -0,0 +1,85 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	"github.com/dancavallaro/kubectl-unmount/pkg/kube/kubetest"
+	"github.com/dancavallaro/kubectl-unmount/pkg/scaling"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// newSuspendedApplication creates an Argo CD Application whose automated sync was disabled by unmount.
+func newSuspendedApplication(name string) *unstructured.Unstructured {
+	app := &unstructured.Unstructured{Object: map[string]any{"spec": map[string]any{}}}
+	app.SetAPIVersion("argoproj.io/v1alpha1")
+	app.SetKind("Application")
+	app.SetNamespace("argocd")
+	app.SetName(name)
+	app.SetAnnotations(map[string]string{common.AnnotationOriginalAutomatedSync: `{"selfHeal":true}`})
+	return app
+}
+
+func TestAbortResumesGitOps(t *testing.T) {
+	db := PlanController{APIVersion: "apps/v1", Kind: common.KindDeployment, Namespace: testNamespace, Name: "db"}
+	web := PlanController{APIVersion: "apps/v1", Kind: common.KindDeployment, Namespace: testNamespace, Name: "web"}
+	dbApp := scaling.GitOpsOwner{Kind: "Application", Namespace: "argocd", Name: "db-app"}
+	webApp := scaling.GitOpsOwner{Kind: "Application", Namespace: "argocd", Name: "web-app"}
+
+	tests := []struct {
+		name        string
+		atomic      bool
+		dbResult    Result
+		wantResumed []string
+	}{
+		{
+			name:        "suspend failed before scaling down",
+			wantResumed: []string{"db-app", "web-app"},
+		},
+		{
+			name:        "scaling down failed",
+			dbResult:    ResultFailed,
+			wantResumed: []string{"db-app", "web-app"},
+		},
+		{
+			name:        "owners of controllers scaled down are left for restore",
+			dbResult:    ResultSucceeded,
+			wantResumed: []string{"web-app"},
+		},
+		{
+			name:        "atomic",
+			atomic:      true,
+			dbResult:    ResultSucceeded,
+			wantResumed: []string{"db-app", "web-app"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients := kubetest.NewClients(newSuspendedApplication("db-app"), newSuspendedApplication("web-app"))
+			var logBuf, outBuf bytes.Buffer
+			cfg := newTestConfig(&logBuf, &outBuf, scaling.DryRunNone)
+			cfg.Atomic = common.BoolP(tt.atomic)
+			scaler := scaling.New(clients.Clients, cfg.logger, cfg.scalingOptions())
+
+			plan := &Plan{Controllers: []PlanController{db, web}}
+			plan.Controllers[0].Result = tt.dbResult
+			suspended := gitOpsOwners{db.ref(): {dbApp}, web.ref(): {webApp}}
+
+			err := cfg.abort(context.Background(), scaler, plan, suspended, errors.New("scale denied"))
+			require.EqualError(t, err, "scale denied")
+
+			var resumed []string
+			for _, action := range clients.FakeDynamic.Actions() {
+				if action.GetVerb() == "patch" && action.GetResource().Resource == "applications" {
+					resumed = append(resumed, action.(interface{ GetName() string }).GetName())
+				}
+			}
+			require.Equal(t, tt.wantResumed, resumed)
+		})
+	}
+}
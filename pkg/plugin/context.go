@@ -0,0 +1,70 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// ErrInterrupted is returned when the plugin is stopped by SIGINT or SIGTERM.
+var ErrInterrupted = errors.New("interrupted")
+
+// TimeoutError is returned when the plugin doesn't finish before the configured --timeout.
+type TimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %v", e.Timeout)
+}
+
+// newContext creates a context which is cancelled on SIGINT/SIGTERM, or once the configured timeout expires.
+// The timeout only starts once startTimeout is called, so that time spent finding what to act on and waiting
+// for confirmation doesn't count towards it.
+func (cfg *ConfigFlags) newContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	if cfg.Timeout == nil || *cfg.Timeout <= 0 {
+		return ctx, stop
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	var timer *time.Timer
+	cfg.timeoutStarter = func() {
+		timer = time.AfterFunc(*cfg.Timeout, func() { cancel(&TimeoutError{Timeout: *cfg.Timeout}) })
+	}
+	return ctx, func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		cancel(nil)
+		stop()
+	}
+}
+
+// startTimeout starts the timeout of the context created by newContext, once the action has been confirmed.
+func (cfg *ConfigFlags) startTimeout() {
+	if cfg.timeoutStarter != nil {
+		cfg.timeoutStarter()
+		cfg.timeoutStarter = nil
+	}
+}
+
+// contextError replaces errors caused by the context being cancelled with a more descriptive error.
+func (cfg *ConfigFlags) contextError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	var timeoutErr *TimeoutError
+	switch {
+	case errors.As(context.Cause(ctx), &timeoutErr):
+		return timeoutErr
+	case errors.Is(ctx.Err(), context.Canceled):
+		return ErrInterrupted
+	default:
+		return err
+	}
+}
@@ -0,0 +1,35 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewContextStartsTimeoutOnConfirmation(t *testing.T) {
+	cfg := &ConfigFlags{Timeout: common.DurationP(50 * time.Millisecond)}
+	ctx, cancel := cfg.newContext()
+	defer cancel()
+
+	// Time spent before confirmation doesn't count
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, ctx.Err())
+
+	cfg.startTimeout()
+	<-ctx.Done()
+	require.Equal(t, &TimeoutError{Timeout: 50 * time.Millisecond}, cfg.contextError(ctx, errors.New("waiting")))
+}
+
+func TestNewContextWithoutTimeout(t *testing.T) {
+	cfg := &ConfigFlags{Timeout: common.DurationP(0)}
+	ctx, cancel := cfg.newContext()
+	cfg.startTimeout()
+	require.NoError(t, ctx.Err())
+
+	cancel()
+	require.Equal(t, ErrInterrupted, cfg.contextError(ctx, context.Canceled))
+}
@@ -0,0 +1,88 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/discovery"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// accessModeAbbreviations maps the short access mode names shown by kubectl to the full names.
+var accessModeAbbreviations = map[string]corev1.PersistentVolumeAccessMode{
+	"RWO":  corev1.ReadWriteOnce,
+	"ROX":  corev1.ReadOnlyMany,
+	"RWX":  corev1.ReadWriteMany,
+	"RWOP": corev1.ReadWriteOncePod,
+}
+
+// pvcFilter builds the PVC filter from the config, validating the values of each flag.
+func (cfg *ConfigFlags) pvcFilter() (discovery.PVCFilter, error) {
+	filter := discovery.PVCFilter{}
+	if cfg.Namespace != nil {
+		filter.Namespace = *cfg.Namespace
+	}
+	if cfg.StorageClass != nil {
+		filter.StorageClass = *cfg.StorageClass
+	}
+	if cfg.Selector != nil {
+		filter.Selector = *cfg.Selector
+	}
+	if cfg.CSIDriver != nil {
+		filter.CSIDriver = *cfg.CSIDriver
+	}
+
+	if cfg.AccessModes != nil {
+		for _, mode := range *cfg.AccessModes {
+			accessMode, err := parseAccessMode(mode)
+			if err != nil {
+				return discovery.PVCFilter{}, err
+			}
+			filter.AccessModes = append(filter.AccessModes, accessMode)
+		}
+	}
+
+	if cfg.Phase != nil && *cfg.Phase != "" {
+		phase := corev1.PersistentVolumeClaimPhase(*cfg.Phase)
+		switch phase {
+		case corev1.ClaimPending, corev1.ClaimBound, corev1.ClaimLost:
+			filter.Phase = phase
+		default:
+			return discovery.PVCFilter{}, fmt.Errorf("invalid --phase %q, must be one of Pending, Bound or Lost", *cfg.Phase)
+		}
+	}
+
+	var err error
+	if filter.MinCapacity, err = parseCapacity("--min-capacity", cfg.MinCapacity); err != nil {
+		return discovery.PVCFilter{}, err
+	}
+	if filter.MaxCapacity, err = parseCapacity("--max-capacity", cfg.MaxCapacity); err != nil {
+		return discovery.PVCFilter{}, err
+	}
+
+	return filter, nil
+}
+
+func parseAccessMode(mode string) (corev1.PersistentVolumeAccessMode, error) {
+	if accessMode, ok := accessModeAbbreviations[strings.ToUpper(mode)]; ok {
+		return accessMode, nil
+	}
+	for _, accessMode := range accessModeAbbreviations {
+		if string(accessMode) == mode {
+			return accessMode, nil
+		}
+	}
+	return "", fmt.Errorf("invalid --access-mode %q, must be one of RWO, ROX, RWX, RWOP (or the full name)", mode)
+}
+
+func parseCapacity(flag string, value *string) (*resource.Quantity, error) {
+	if value == nil || *value == "" {
+		return nil, nil
+	}
+	quantity, err := resource.ParseQuantity(*value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %w", flag, *value, err)
+	}
+	return &quantity, nil
+}
@@ -0,0 +1,131 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	"github.com/dancavallaro/kubectl-unmount/pkg/scaling"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// GitOpsMode is what to do with controllers reconciled by Argo CD or Flux, which would undo the unmount.
+type GitOpsMode string
+
+const (
+	GitOpsModeRefuse  GitOpsMode = "refuse"
+	GitOpsModeSuspend GitOpsMode = "suspend"
+	GitOpsModeIgnore  GitOpsMode = "ignore"
+)
+
+var GitOpsModes = []GitOpsMode{GitOpsModeRefuse, GitOpsModeSuspend, GitOpsModeIgnore}
+
+// gitOpsOwners maps each GitOps managed controller to the objects reconciling it.
+type gitOpsOwners map[common.ControllerRef][]scaling.GitOpsOwner
+
+func findGitOpsOwners(ctx context.Context, scaler scaling.Scaler, controllers []common.ControllerRef,
+	objects controllerObjects) gitOpsOwners {
+	objs := make([]*unstructured.Unstructured, len(controllers))
+	for i, ctrl := range controllers {
+		objs[i] = objects[ctrl]
+	}
+	owners := gitOpsOwners{}
+	for i, ctrlOwners := range scaler.FindGitOpsOwners(ctx, objs) {
+		if len(ctrlOwners) > 0 {
+			owners[controllers[i]] = ctrlOwners
+		}
+	}
+	return owners
+}
+
+// Unique returns every GitOps owner once, sorted so that they're suspended in a deterministic order.
+func (o gitOpsOwners) Unique() []scaling.GitOpsOwner {
+	var unique []scaling.GitOpsOwner
+	for _, owners := range o {
+		for _, owner := range owners {
+			if !slices.Contains(unique, owner) {
+				unique = append(unique, owner)
+			}
+		}
+	}
+	slices.SortFunc(unique, func(a, b scaling.GitOpsOwner) int { return strings.Compare(a.String(), b.String()) })
+	return unique
+}
+
+func (cfg *ConfigFlags) gitOpsMode() GitOpsMode {
+	if cfg.GitOps == nil || *cfg.GitOps == "" {
+		return GitOpsModeRefuse
+	}
+	return GitOpsMode(*cfg.GitOps)
+}
+
+// checkGitOps decides whether the run can go ahead when some controllers are reconciled by GitOps.
+func (cfg *ConfigFlags) checkGitOps(owners gitOpsOwners, controllers []common.ControllerRef) error {
+	if len(owners) == 0 {
+		return nil
+	}
+
+	var managed []string
+	for _, ctrl := range controllers {
+		if ctrlOwners, ok := owners[ctrl]; ok {
+			managed = append(managed, fmt.Sprintf("  %v (managed by %s)", ctrl, formatGitOpsOwners(ctrlOwners)))
+		}
+	}
+
+	switch cfg.gitOpsMode() {
+	case GitOpsModeSuspend:
+		cfg.logger.Info("%d GitOps owner(s) will be suspended until restore:\n%s", len(owners.Unique()),
+			strings.Join(managed, "\n"))
+	case GitOpsModeIgnore:
+		cfg.logger.Warn("%d controller(s) are managed by GitOps and may be scaled back up by it:\n%s", len(managed),
+			strings.Join(managed, "\n"))
+	default:
+		return fmt.Errorf("%d controller(s) are managed by GitOps, which would scale them back up:\n%s\n"+
+			"Re-run with --gitops=suspend to suspend reconciliation until restore, or --gitops=ignore to proceed anyway",
+			len(managed), strings.Join(managed, "\n"))
+	}
+	return nil
+}
+
+// suspendGitOps suspends every GitOps owner, stopping at the first failure since scaling down would be undone.
+// Returns the owners suspended so far, even on failure, so that they can be resumed if the run is aborted.
+func suspendGitOps(ctx context.Context, cfg *ConfigFlags, scaler scaling.Scaler,
+	owners gitOpsOwners) (gitOpsOwners, error) {
+	suspended := gitOpsOwners{}
+	if cfg.gitOpsMode() != GitOpsModeSuspend {
+		return suspended, nil
+	}
+	for _, owner := range owners.Unique() {
+		if err := scaler.SuspendGitOps(ctx, owner); err != nil {
+			return suspended, err
+		}
+		for ctrl, ctrlOwners := range owners {
+			if slices.Contains(ctrlOwners, owner) {
+				suspended[ctrl] = append(suspended[ctrl], owner)
+			}
+		}
+	}
+	return suspended, nil
+}
+
+// resumeGitOps resumes the given GitOps owners, returning the number of errors.
+func resumeGitOps(ctx context.Context, cfg *ConfigFlags, scaler scaling.Scaler, owners []scaling.GitOpsOwner) int {
+	errors := 0
+	for _, owner := range owners {
+		if err := scaler.ResumeGitOps(ctx, owner); err != nil {
+			cfg.logger.Error(err)
+			errors++
+		}
+	}
+	return errors
+}
+
+func formatGitOpsOwners(owners []scaling.GitOpsOwner) string {
+	names := make([]string, len(owners))
+	for i, owner := range owners {
+		names[i] = owner.String()
+	}
+	return strings.Join(names, ", ")
+}
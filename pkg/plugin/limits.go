@@ -0,0 +1,47 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+)
+
+// limit is a cap on how much a single run may affect.
+type limit struct {
+	name  string
+	max   *int
+	count int
+}
+
+// checkLimits aborts the run if the plan affects more controllers, pods or namespaces than allowed, before
+// anything is modified. A limit of 0 means no limit. Only the namespaces of the pods and controllers acted on
+// count, not those of matched PVCs which no pod mounts.
+func (cfg *ConfigFlags) checkLimits(plan *Plan, pods int) error {
+	namespaces := make(map[string]bool)
+	for _, vol := range plan.Volumes {
+		if len(vol.Pods) > 0 {
+			namespaces[vol.Namespace] = true
+		}
+	}
+	for _, ctrl := range plan.Controllers {
+		namespaces[ctrl.Namespace] = true
+	}
+
+	limits := []limit{
+		{name: "controllers", max: cfg.MaxControllers, count: len(plan.Controllers)},
+		{name: "pods", max: cfg.MaxPods, count: pods},
+		{name: "namespaces", max: cfg.MaxNamespaces, count: len(namespaces)},
+	}
+
+	var exceeded []string
+	for _, l := range limits {
+		if l.max == nil || *l.max <= 0 || l.count <= *l.max {
+			continue
+		}
+		exceeded = append(exceeded, fmt.Sprintf("%d %s (limit %d, %d over)", l.count, l.name, *l.max, l.count-*l.max))
+	}
+	if len(exceeded) > 0 {
+		return fmt.Errorf("plan exceeds --max-* limits, aborting without changing anything: %s",
+			strings.Join(exceeded, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckLimits(t *testing.T) {
+	volume := func(ns, name string, pods ...string) PlanVolume {
+		vol := PlanVolume{Namespace: ns, Name: name, Pods: []PlanPod{}}
+		for _, pod := range pods {
+			vol.Pods = append(vol.Pods, PlanPod{Name: pod, Controller: "Deployment/" + ns + "/" + pod})
+		}
+		return vol
+	}
+	controller := func(ns, name string) PlanController {
+		return PlanController{APIVersion: "apps/v1", Kind: common.KindDeployment, Namespace: ns, Name: name}
+	}
+
+	tests := []struct {
+		name      string
+		plan      *Plan
+		pods      int
+		configure func(cfg *ConfigFlags)
+		wantErr   string
+	}{
+		{
+			name: "no limits",
+			plan: &Plan{
+				Volumes:     []PlanVolume{volume("ns-a", "data", "db"), volume("ns-b", "data", "db")},
+				Controllers: []PlanController{controller("ns-a", "db"), controller("ns-b", "db")},
+			},
+			pods: 2,
+		},
+		{
+			name: "within limits",
+			plan: &Plan{
+				Volumes:     []PlanVolume{volume("ns-a", "data", "db")},
+				Controllers: []PlanController{controller("ns-a", "db")},
+			},
+			pods: 1,
+			configure: func(cfg *ConfigFlags) {
+				cfg.MaxControllers = common.IntP(1)
+				cfg.MaxPods = common.IntP(1)
+				cfg.MaxNamespaces = common.IntP(1)
+			},
+		},
+		{
+			name: "unmounted PVC in another namespace",
+			plan: &Plan{
+				Volumes:     []PlanVolume{volume("ns-a", "data", "db"), volume("ns-b", "unused")},
+				Controllers: []PlanController{controller("ns-a", "db")},
+			},
+			pods:      1,
+			configure: func(cfg *ConfigFlags) { cfg.MaxNamespaces = common.IntP(1) },
+		},
+		{
+			name: "too many namespaces",
+			plan: &Plan{
+				Volumes:     []PlanVolume{volume("ns-a", "data", "db"), volume("ns-b", "data", "db"), volume("ns-c", "unused")},
+				Controllers: []PlanController{controller("ns-a", "db"), controller("ns-b", "db")},
+			},
+			pods:      2,
+			configure: func(cfg *ConfigFlags) { cfg.MaxNamespaces = common.IntP(1) },
+			wantErr:   "plan exceeds --max-* limits, aborting without changing anything: 2 namespaces (limit 1, 1 over)",
+		},
+		{
+			name: "too many controllers and pods",
+			plan: &Plan{
+				Volumes:     []PlanVolume{volume("ns-a", "data", "db", "web")},
+				Controllers: []PlanController{controller("ns-a", "db"), controller("ns-a", "web")},
+			},
+			pods: 3,
+			configure: func(cfg *ConfigFlags) {
+				cfg.MaxControllers = common.IntP(1)
+				cfg.MaxPods = common.IntP(2)
+			},
+			wantErr: "plan exceeds --max-* limits, aborting without changing anything: " +
+				"2 controllers (limit 1, 1 over), 3 pods (limit 2, 1 over)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &ConfigFlags{}
+			if tt.configure != nil {
+				tt.configure(cfg)
+			}
+			err := cfg.checkLimits(tt.plan, tt.pods)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
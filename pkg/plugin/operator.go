@@ -0,0 +1,41 @@
+package plugin
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	"github.com/dancavallaro/kubectl-unmount/pkg/discovery"
+	"github.com/dancavallaro/kubectl-unmount/pkg/scaling"
+)
+
+// warnOperatorManaged warns about controllers acted on below the top of their owner chain (with
+// --owner-level), when an owner above them is a custom resource whose operator would likely revert them
+// being scaled down.
+func warnOperatorManaged(cfg *ConfigFlags, scaler scaling.Scaler, podControllers discovery.PodControllers,
+	chains discovery.OwnerChains) {
+	warned := make(map[common.ControllerRef]bool)
+	for _, key := range slices.Sorted(maps.Keys(podControllers)) {
+		ctrl := podControllers[key]
+		chain := chains[key]
+		// VirtualMachineInstances are stopped through their VirtualMachine, rather than being deleted
+		if warned[ctrl] || scaling.IsVirtualMachine(ctrl) {
+			continue
+		}
+		for _, owner := range chain[slices.Index(chain, ctrl)+1:] {
+			// Any owner outside the built-in groups is a custom resource, whose operator may revert changes
+			// made to the controllers below it
+			if common.IsBuiltinGroup(owner.GroupVersionKind().Group) {
+				continue
+			}
+			warned[ctrl] = true
+			if operator, ok := scaler.Operator(owner); ok {
+				cfg.logger.Warn("%v is managed by %v, which %s would scale straight back up (use --owner-level=0 to quiesce it through %s instead)",
+					ctrl, owner, operator, operator)
+			} else {
+				cfg.logger.Warn("%v is managed by %v, whose operator may scale it straight back up", ctrl, owner)
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/cli-runtime/pkg/printers"
+)
+
+const (
+	outputFormatWide = "wide"
+	outputFormatName = "name"
+)
+
+// AddOutputFlags registers --output, which supports every format of cli-runtime's printers plus "wide".
+func AddOutputFlags(cmd *cobra.Command, printFlags *genericclioptions.PrintFlags) {
+	printFlags.AddFlags(cmd)
+	formats := append(printFlags.AllowedFormats(), outputFormatWide)
+	cmd.Flags().Lookup("output").Usage = fmt.Sprintf("Output format for the plan. One of: (%s).", strings.Join(formats, ", "))
+}
+
+// outputFormat returns the requested --output format, or an empty string for the default human-readable
+// output.
+func (cfg *ConfigFlags) outputFormat() string {
+	if cfg.PrintFlags == nil || cfg.PrintFlags.OutputFormat == nil {
+		return ""
+	}
+	return *cfg.PrintFlags.OutputFormat
+}
+
+// validateOutput checks that the requested output format is supported, before anything is done.
+func (cfg *ConfigFlags) validateOutput() error {
+	switch cfg.outputFormat() {
+	case "", outputFormatWide:
+		return nil
+	default:
+		_, err := cfg.PrintFlags.ToPrinter()
+		return err
+	}
+}
+
+// printPlan prints the plan in the requested output format.
+func (cfg *ConfigFlags) printPlan(plan *Plan) error {
+	switch cfg.outputFormat() {
+	case "":
+		return nil
+	case outputFormatWide:
+		return printers.NewTablePrinter(printers.PrintOptions{Wide: true}).PrintObj(plan.toTable(), cfg.out)
+	case outputFormatName:
+		printer, err := cfg.PrintFlags.ToPrinter()
+		if err != nil {
+			return err
+		}
+		return printer.PrintObj(plan.controllerList(), cfg.out)
+	default:
+		printer, err := cfg.PrintFlags.ToPrinter()
+		if err != nil {
+			return err
+		}
+		obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(plan)
+		if err != nil {
+			return fmt.Errorf("failed to convert plan: %w", err)
+		}
+		return printer.PrintObj(&unstructured.Unstructured{Object: obj}, cfg.out)
+	}
+}
+
+// toTable renders the plan as a kubectl-style table, with one row per pod (or per PVC without any pods).
+func (p *Plan) toTable() *metav1.Table {
+	table := &metav1.Table{
+		ColumnDefinitions: []metav1.TableColumnDefinition{
+			{Name: "Namespace", Type: "string"},
+			{Name: "PVC", Type: "string"},
+			{Name: "Pod", Type: "string"},
+			{Name: "Node", Type: "string", Priority: 1},
+			{Name: "Controller", Type: "string"},
+			{Name: "Owners", Type: "string", Priority: 1},
+			{Name: "Replicas", Type: "string"},
+			{Name: "Action", Type: "string"},
+			{Name: "Result", Type: "string", Priority: 1},
+		},
+	}
+
+	controllers := make(map[string]PlanController)
+	for _, ctrl := range p.Controllers {
+		controllers[ctrl.ref().String()] = ctrl
+	}
+
+	for _, vol := range p.Volumes {
+		if len(vol.Pods) == 0 {
+			table.Rows = append(table.Rows, metav1.TableRow{
+				Cells: []any{vol.Namespace, vol.Name, "<none>", "<none>", "<none>", "<none>", "-", "-", "-"},
+			})
+			continue
+		}
+		for _, pod := range vol.Pods {
+			ctrl := controllers[pod.Controller]
+			table.Rows = append(table.Rows, metav1.TableRow{
+				Cells: []any{vol.Namespace, vol.Name, pod.Name, valueOrNone(pod.Node), pod.Controller,
+					valueOrNone(strings.Join(pod.Owners, "->")), formatReplicas(ctrl), valueOrNone(string(ctrl.Action)), valueOrNone(string(ctrl.Result))},
+			})
+		}
+	}
+
+	return table
+}
+
+// controllerList returns the plan's controllers as a list of objects, so they can be printed by name.
+func (p *Plan) controllerList() *unstructured.UnstructuredList {
+	list := &unstructured.UnstructuredList{}
+	list.SetAPIVersion("v1")
+	list.SetKind("List")
+
+	for _, ctrl := range p.Controllers {
+		item := unstructured.Unstructured{}
+		item.SetGroupVersionKind(ctrl.ref().GroupVersionKind())
+		item.SetNamespace(ctrl.Namespace)
+		item.SetName(ctrl.Name)
+		list.Items = append(list.Items, item)
+	}
+	return list
+}
+
+func valueOrNone(val string) string {
+	if val == "" {
+		return "<none>"
+	}
+	return val
+}
@@ -0,0 +1,113 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/kube/kubetest"
+	"github.com/dancavallaro/kubectl-unmount/pkg/scaling"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/yaml"
+)
+
+func newTestPlan() *Plan {
+	return &Plan{
+		TypeMeta: metav1.TypeMeta{APIVersion: planAPIVersion, Kind: planKind},
+		Volumes: []PlanVolume{
+			{Namespace: testNamespace, Name: "data", Pods: []PlanPod{{
+				Name:       "web-pod",
+				Node:       "node-1",
+				Controller: "Deployment/test-ns/web",
+				Owners:     []string{"ReplicaSet/test-ns/web-rs", "Deployment/test-ns/web"},
+			}}},
+			{Namespace: testNamespace, Name: "unused", Pods: []PlanPod{}},
+		},
+		Controllers: []PlanController{{
+			APIVersion:      "apps/v1",
+			Kind:            "Deployment",
+			Namespace:       testNamespace,
+			Name:            "web",
+			CurrentReplicas: ptr.To[int32](2),
+			TargetReplicas:  ptr.To[int32](0),
+			Action:          scaling.ActionScaleDown,
+			Result:          ResultSucceeded,
+		}},
+		Excluded: []PlanExclusion{{Kind: "PersistentVolumeClaim", Namespace: "kube-system", Name: "etcd", Reason: reasonProtectedNamespace}},
+	}
+}
+
+func TestPlanToTable(t *testing.T) {
+	table := newTestPlan().toTable()
+
+	var columns []string
+	for _, column := range table.ColumnDefinitions {
+		columns = append(columns, column.Name)
+	}
+	require.Equal(t, []string{"Namespace", "PVC", "Pod", "Node", "Controller", "Owners", "Replicas", "Action", "Result"}, columns)
+	require.Equal(t, []metav1.TableRow{
+		{Cells: []any{testNamespace, "data", "web-pod", "node-1", "Deployment/test-ns/web",
+			"ReplicaSet/test-ns/web-rs->Deployment/test-ns/web", "2->0", "ScaleDown", "Succeeded"}},
+		{Cells: []any{testNamespace, "unused", "<none>", "<none>", "<none>", "<none>", "-", "-", "-"}},
+	}, table.Rows)
+}
+
+func TestPrintPlan(t *testing.T) {
+	tests := []struct {
+		format    string
+		unmarshal func(data []byte, v any) error
+	}{
+		{format: "json", unmarshal: json.Unmarshal},
+		{format: "yaml", unmarshal: func(data []byte, v any) error { return yaml.Unmarshal(data, v) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var out bytes.Buffer
+			cfg := &ConfigFlags{PrintFlags: genericclioptions.NewPrintFlags("").WithDefaultOutput(tt.format), out: &out}
+
+			require.NoError(t, cfg.printPlan(newTestPlan()))
+			var plan Plan
+			require.NoError(t, tt.unmarshal(out.Bytes(), &plan))
+			require.Equal(t, newTestPlan(), &plan)
+		})
+	}
+}
+
+func TestPrintPlanWide(t *testing.T) {
+	var out bytes.Buffer
+	cfg := &ConfigFlags{PrintFlags: genericclioptions.NewPrintFlags("").WithDefaultOutput(outputFormatWide), out: &out}
+
+	require.NoError(t, cfg.printPlan(newTestPlan()))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 3)
+	require.Equal(t, []string{"NAMESPACE", "PVC", "POD", "NODE", "CONTROLLER", "OWNERS", "REPLICAS", "ACTION", "RESULT"},
+		strings.Fields(lines[0]))
+	require.Equal(t, []string{testNamespace, "data", "web-pod", "node-1", "Deployment/test-ns/web",
+		"ReplicaSet/test-ns/web-rs->Deployment/test-ns/web", "2->0", "ScaleDown", "Succeeded"}, strings.Fields(lines[1]))
+}
+
+func TestRunPrintsPlanWhenCancelled(t *testing.T) {
+	objs := append(newTestDeployment("test-deployment", "test-pvc"), newTestPVC("test-pvc"))
+	clients := kubetest.NewClients(objs...)
+	var logBuf, outBuf bytes.Buffer
+	cfg := newTestConfig(&logBuf, &outBuf, scaling.DryRunNone)
+	cfg.Confirmed = nil
+	cfg.in = strings.NewReader("no\n")
+	cfg.PrintFlags = genericclioptions.NewPrintFlags("").WithDefaultOutput("yaml")
+
+	require.NoError(t, run(context.Background(), cfg, clients.Clients))
+	require.Contains(t, logBuf.String(), "Operation cancelled by user")
+	var plan Plan
+	require.NoError(t, yaml.Unmarshal(outBuf.Bytes(), &plan))
+	require.Equal(t, planKind, plan.Kind)
+	require.Len(t, plan.Controllers, 1)
+	require.Equal(t, "test-deployment", plan.Controllers[0].Name)
+	require.Empty(t, plan.Controllers[0].Result)
+	require.Empty(t, writeActions(clients))
+}
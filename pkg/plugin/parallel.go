@@ -0,0 +1,77 @@
+package plugin
+
+import (
+	"github.com/dancavallaro/kubectl-unmount/pkg/discovery"
+	"github.com/dancavallaro/kubectl-unmount/pkg/logger"
+	"golang.org/x/sync/errgroup"
+	"k8s.io/client-go/rest"
+)
+
+func (cfg *ConfigFlags) parallelism() int {
+	if cfg.Parallelism == nil {
+		return 1
+	}
+	return max(*cfg.Parallelism, 1)
+}
+
+// finderOptions returns the options for the Finder.
+func (cfg *ConfigFlags) finderOptions() discovery.Options {
+	opts := discovery.Options{Parallelism: cfg.parallelism(), Paging: cfg.paging()}
+	if cfg.MaxOwnerDepth != nil {
+		opts.MaxOwnerDepth = *cfg.MaxOwnerDepth
+	}
+	if cfg.OwnerLevel != nil {
+		opts.OwnerLevel = *cfg.OwnerLevel
+	}
+	return opts
+}
+
+// wrapRESTConfig applies --qps and --burst to the REST client, when they're set.
+func (cfg *ConfigFlags) wrapRESTConfig(config *rest.Config) *rest.Config {
+	if cfg.QPS != nil && *cfg.QPS > 0 {
+		config.QPS = *cfg.QPS
+	}
+	if cfg.Burst != nil && *cfg.Burst > 0 {
+		config.Burst = *cfg.Burst
+	}
+	return config
+}
+
+// forEachInOrder calls fn for each of n items, with up to parallelism calls at a time. Each call gets its own
+// buffered logger, and the buffers are flushed in item order as calls complete, so the output is the same as
+// if the items had been processed one at a time. No more calls are started once stop returns true.
+func forEachInOrder(log *logger.Logger, n, parallelism int, stop func() bool, fn func(i int, log *logger.Logger)) {
+	logs := make([]*logger.Logger, n)
+	done := make([]chan struct{}, n)
+	for i := range n {
+		logs[i] = log.Buffer()
+		done[i] = make(chan struct{})
+	}
+
+	flushed := make(chan struct{})
+	go func() {
+		defer close(flushed)
+		for i := range n {
+			<-done[i]
+			logs[i].Flush()
+		}
+	}()
+
+	var g errgroup.Group
+	g.SetLimit(parallelism)
+	started := 0
+	for ; started < n && !stop(); started++ {
+		i := started
+		g.Go(func() error {
+			defer close(done[i])
+			fn(i, logs[i])
+			return nil
+		})
+	}
+	for i := started; i < n; i++ {
+		close(done[i])
+	}
+
+	_ = g.Wait()
+	<-flushed
+}
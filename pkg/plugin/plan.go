@@ -0,0 +1,227 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	"github.com/dancavallaro/kubectl-unmount/pkg/discovery"
+	"github.com/dancavallaro/kubectl-unmount/pkg/scaling"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	planAPIVersion = "unmount.kubectl.io/v1alpha1"
+	planKind       = "Plan"
+)
+
+// Result describes the outcome of the action taken on a controller.
+type Result string
+
+const (
+	ResultSucceeded Result = "Succeeded"
+	ResultFailed    Result = "Failed"
+	ResultDryRun    Result = "DryRun"
+	// ResultNotRestorable is the rollback result of controllers which were deleted, so can't be rolled back.
+	ResultNotRestorable Result = "NotRestorable"
+)
+
+// Plan is the structured description of what a run affects, printed with --output.
+type Plan struct {
+	metav1.TypeMeta `json:",inline"`
+
+	Volumes     []PlanVolume     `json:"volumes"`
+	Controllers []PlanController `json:"controllers"`
+	Excluded    []PlanExclusion  `json:"excluded,omitempty"`
+}
+
+// PlanVolume is a matched PVC, along with the pods mounting it.
+type PlanVolume struct {
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	Pods      []PlanPod `json:"pods"`
+}
+
+// PlanPod is a pod mounting a matched PVC, along with the controller acted on and the pod's whole owner chain.
+type PlanPod struct {
+	Name       string   `json:"name"`
+	Node       string   `json:"node,omitempty"`
+	Controller string   `json:"controller"`
+	Owners     []string `json:"owners,omitempty"`
+}
+
+// PlanController is a controller acted on, along with the action taken on it.
+type PlanController struct {
+	APIVersion      string         `json:"apiVersion"`
+	Kind            string         `json:"kind"`
+	Namespace       string         `json:"namespace"`
+	Name            string         `json:"name"`
+	CurrentReplicas *int32         `json:"currentReplicas,omitempty"`
+	TargetReplicas  *int32         `json:"targetReplicas,omitempty"`
+	Action          scaling.Action `json:"action"`
+	Autoscalers     []string       `json:"autoscalers,omitempty"`
+	ManagedBy       []string       `json:"managedBy,omitempty"`
+	Result          Result         `json:"result,omitempty"`
+	Error           string         `json:"error,omitempty"`
+	Rollback        Result         `json:"rollback,omitempty"`
+	RollbackError   string         `json:"rollbackError,omitempty"`
+}
+
+// PlanExclusion is a matched PVC or controller which is left alone because it's protected, along with why.
+type PlanExclusion struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Reason    string `json:"reason"`
+}
+
+func (e PlanExclusion) ref() string {
+	return fmt.Sprintf("%s/%s/%s", e.Kind, e.Namespace, e.Name)
+}
+
+func (c PlanController) ref() common.ControllerRef {
+	return common.ControllerRef{
+		APIVersion: c.APIVersion,
+		Kind:       c.Kind,
+		Namespace:  c.Namespace,
+		Name:       c.Name,
+	}
+}
+
+// newPlan builds a plan from the discovered PVCs, pods and controllers. Volumes and controllers are sorted
+// so that output is deterministic.
+func newPlan(pvcsPerNs map[string][]string, pods []corev1.Pod, podControllers discovery.PodControllers) *Plan {
+	plan := &Plan{
+		TypeMeta:    metav1.TypeMeta{APIVersion: planAPIVersion, Kind: planKind},
+		Volumes:     []PlanVolume{},
+		Controllers: []PlanController{},
+	}
+
+	for _, ns := range slices.Sorted(maps.Keys(pvcsPerNs)) {
+		for _, pvc := range slices.Sorted(slices.Values(pvcsPerNs[ns])) {
+			vol := PlanVolume{Namespace: ns, Name: pvc, Pods: []PlanPod{}}
+			for _, pod := range pods {
+				if pod.Namespace != ns || len(discovery.MountedPVCs(pod, []string{pvc})) == 0 {
+					continue
+				}
+				vol.Pods = append(vol.Pods, PlanPod{
+					Name:       pod.Name,
+					Node:       pod.Spec.NodeName,
+					Controller: podControllers[pod.Namespace+"/"+pod.Name].String(),
+				})
+			}
+			slices.SortFunc(vol.Pods, func(a, b PlanPod) int { return strings.Compare(a.Name, b.Name) })
+			plan.Volumes = append(plan.Volumes, vol)
+		}
+	}
+
+	for _, ctrl := range podControllers.Unique() {
+		plan.Controllers = append(plan.Controllers, PlanController{
+			APIVersion: ctrl.APIVersion,
+			Kind:       ctrl.Kind,
+			Namespace:  ctrl.Namespace,
+			Name:       ctrl.Name,
+		})
+	}
+
+	return plan
+}
+
+// pvcsOf returns the PVCs mounted by the pods of the given controller.
+func (p *Plan) pvcsOf(ctrl common.ControllerRef) []string {
+	var pvcs []string
+	for _, vol := range p.Volumes {
+		if vol.Namespace == ctrl.Namespace && slices.ContainsFunc(vol.Pods, func(pod PlanPod) bool {
+			return pod.Controller == ctrl.String()
+		}) {
+			pvcs = append(pvcs, vol.Name)
+		}
+	}
+	return pvcs
+}
+
+// withOwners records the owner chain of each pod owned by a controller, from the pod's controller up to the
+// top-level owner.
+func (p *Plan) withOwners(chains discovery.OwnerChains) *Plan {
+	for i := range p.Volumes {
+		vol := &p.Volumes[i]
+		for j := range vol.Pods {
+			chain := chains[vol.Namespace+"/"+vol.Pods[j].Name]
+			if len(chain) > 0 && chain.Top().Kind != common.KindPod {
+				vol.Pods[j].Owners = chain.Strings()
+			}
+		}
+	}
+	return p
+}
+
+// withExclusions records the PVCs and controllers left out of the plan because they're protected.
+func (p *Plan) withExclusions(exclusions []PlanExclusion) *Plan {
+	p.Excluded = slices.SortedFunc(slices.Values(exclusions), func(a, b PlanExclusion) int {
+		return strings.Compare(a.ref(), b.ref())
+	})
+	return p
+}
+
+// describe fills in the action that will be taken on each controller in the plan.
+func (p *Plan) describe(ctx context.Context, scaler scaling.Scaler, objects controllerObjects) error {
+	for i := range p.Controllers {
+		ctrl := &p.Controllers[i]
+		desc, err := scaler.Describe(ctx, ctrl.ref(), objects[ctrl.ref()])
+		if err != nil {
+			return err
+		}
+		ctrl.Action = desc.Action
+		ctrl.CurrentReplicas = desc.CurrentReplicas
+		ctrl.TargetReplicas = desc.TargetReplicas
+		ctrl.Autoscalers = desc.Autoscalers
+	}
+	return nil
+}
+
+// setManagedBy records the GitOps owners reconciling each controller in the plan.
+func (p *Plan) setManagedBy(owners gitOpsOwners) {
+	for i := range p.Controllers {
+		ctrl := &p.Controllers[i]
+		for _, owner := range owners[ctrl.ref()] {
+			ctrl.ManagedBy = append(ctrl.ManagedBy, owner.String())
+		}
+	}
+}
+
+// setResult records the outcome of the action taken on a controller.
+func (c *PlanController) setResult(dryRun bool, err error) {
+	switch {
+	case err != nil:
+		c.Result = ResultFailed
+		c.Error = err.Error()
+	case dryRun:
+		c.Result = ResultDryRun
+	default:
+		c.Result = ResultSucceeded
+	}
+}
+
+// setRollbackResult records the outcome of rolling back a controller.
+func (c *PlanController) setRollbackResult(dryRun bool, err error) {
+	switch {
+	case err != nil:
+		c.Rollback = ResultFailed
+		c.RollbackError = err.Error()
+	case dryRun:
+		c.Rollback = ResultDryRun
+	default:
+		c.Rollback = ResultSucceeded
+	}
+}
+
+func formatReplicas(ctrl PlanController) string {
+	if ctrl.CurrentReplicas == nil || ctrl.TargetReplicas == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%d->%d", *ctrl.CurrentReplicas, *ctrl.TargetReplicas)
+}
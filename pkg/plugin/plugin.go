@@ -6,163 +6,475 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"slices"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/dancavallaro/kubectl-unmount/pkg/common"
 	"github.com/dancavallaro/kubectl-unmount/pkg/discovery"
+	"github.com/dancavallaro/kubectl-unmount/pkg/kube"
 	"github.com/dancavallaro/kubectl-unmount/pkg/logger"
 	"github.com/dancavallaro/kubectl-unmount/pkg/scaling"
 	"github.com/dancavallaro/kubectl-unmount/pkg/spinner"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
-	"k8s.io/client-go/kubernetes"
+	"k8s.io/cli-runtime/pkg/resource"
 )
 
 type ConfigFlags struct {
 	genericclioptions.ConfigFlags
 
-	Confirmed    *bool
-	DryRun       *bool
-	StorageClass *string
-	PVCName      *string
+	Confirmed        *bool
+	DryRun           *string
+	StorageClass     *string
+	PVCName          *string
+	Selector         *string
+	AccessModes      *[]string
+	CSIDriver        *string
+	Phase            *string
+	MinCapacity      *string
+	MaxCapacity      *string
+	JobAction        *string
+	FenceDaemonSets  *bool
+	PauseAutoscalers *bool
+	GitOps           *string
+	Atomic           *bool
+	Parallelism      *int
+	QPS              *float32
+	Burst            *int
+	PageSize         *int64
+	FromCache        *bool
+	// ProtectedNamespaces are never unmounted, unless IgnoreProtection is set
+	ProtectedNamespaces *[]string
+	IgnoreProtection    *bool
+	MaxControllers      *int
+	MaxPods             *int
+	MaxNamespaces       *int
+	Preflight           *bool
+	// MaxOwnerDepth is how many owner references are followed up from each pod
+	MaxOwnerDepth *int
+	// OwnerLevel is the level of each pod's owner chain to act on, or 0 for the top-level owner
+	OwnerLevel      *int
+	ArgoCDNamespace *string
+	PVNames         *[]string
+	VolumeHandles   *[]string
+	PVCArgs         []string
+	Filenames       *resource.FilenameOptions
+	WaitForDetach   *bool
+	Timeout         *time.Duration
+	PrintFlags      *genericclioptions.PrintFlags
+	// HandlerRules are loaded from the config file, rather than flags
+	HandlerRules []scaling.HandlerRule
 
 	logger *logger.Logger
+	in     io.Reader
 	out    io.Writer
+	// timeoutStarter starts the Timeout, once the action has been confirmed
+	timeoutStarter func()
 }
 
 func RunPlugin(pluginCfg *ConfigFlags) error {
-	ctx := context.Background()
-	if pluginCfg.logger == nil {
-		pluginCfg.logger = logger.NewLogger(os.Stderr)
+	clients, err := pluginCfg.init()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := pluginCfg.newContext()
+	defer cancel()
+	return pluginCfg.contextError(ctx, run(ctx, pluginCfg, clients))
+}
+
+// init applies defaults to the config and creates the clients from it.
+func (cfg *ConfigFlags) init() (kube.Clients, error) {
+	if cfg.logger == nil {
+		cfg.logger = logger.NewLogger(os.Stderr)
 	}
-	if pluginCfg.out == nil {
-		pluginCfg.out = os.Stdout
+	if cfg.in == nil {
+		cfg.in = os.Stdin
+	}
+	if cfg.out == nil {
+		cfg.out = os.Stdout
 	}
 
-	config, err := pluginCfg.ToRESTConfig()
-	if err != nil {
-		return fmt.Errorf("failed to read kubeconfig: %w", err)
+	cfg.WrapConfigFn = cfg.wrapRESTConfig
+	return kube.NewClients(&cfg.ConfigFlags)
+}
+
+// validate checks flags which can't be validated by cobra, before anything is done.
+func (cfg *ConfigFlags) validate() error {
+	if cfg.JobAction != nil && !slices.Contains(scaling.JobActions, scaling.JobAction(*cfg.JobAction)) {
+		return fmt.Errorf("invalid --job-action %q, must be one of %v", *cfg.JobAction, scaling.JobActions)
+	}
+	if err := cfg.validateDryRun(); err != nil {
+		return err
 	}
+	if cfg.OwnerLevel != nil && *cfg.OwnerLevel < 0 {
+		return fmt.Errorf("invalid --owner-level %d, must be 0 (the top-level owner) or more", *cfg.OwnerLevel)
+	}
+	if cfg.MaxOwnerDepth != nil && *cfg.MaxOwnerDepth < 1 {
+		return fmt.Errorf("invalid --max-owner-depth %d, must be at least 1", *cfg.MaxOwnerDepth)
+	}
+	if !slices.Contains(GitOpsModes, cfg.gitOpsMode()) {
+		return fmt.Errorf("invalid --gitops %q, must be one of %v", *cfg.GitOps, GitOpsModes)
+	}
+	return cfg.validateOutput()
+}
 
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return fmt.Errorf("failed to create clientset: %w", err)
+// dryRunMode returns the requested --dry-run mode. "true" and "false" are still accepted, from when
+// --dry-run was a boolean flag.
+func (cfg *ConfigFlags) dryRunMode() scaling.DryRunMode {
+	if cfg.DryRun == nil {
+		return scaling.DryRunNone
+	}
+	switch *cfg.DryRun {
+	case "", "false":
+		return scaling.DryRunNone
+	case "true":
+		return scaling.DryRunClient
+	default:
+		return scaling.DryRunMode(*cfg.DryRun)
 	}
+}
 
-	return run(ctx, pluginCfg, clientset)
+func (cfg *ConfigFlags) isDryRun() bool {
+	return cfg.dryRunMode() != scaling.DryRunNone
 }
 
-func run(ctx context.Context, cfg *ConfigFlags, clientset *kubernetes.Clientset) error {
-	finder := discovery.New(clientset, cfg.logger)
+func (cfg *ConfigFlags) validateDryRun() error {
+	if !slices.Contains(scaling.DryRunModes, cfg.dryRunMode()) {
+		return fmt.Errorf("invalid --dry-run %q, must be one of %v", *cfg.DryRun, scaling.DryRunModes)
+	}
+	return nil
+}
 
-	filter := discovery.PVCFilter{}
-	if cfg.Namespace != nil {
-		filter.Namespace = *cfg.Namespace
+// scalingOptions returns the options for the Scaler.
+func (cfg *ConfigFlags) scalingOptions() scaling.Options {
+	opts := scaling.Options{DryRun: cfg.dryRunMode()}
+	if cfg.JobAction != nil {
+		opts.JobAction = scaling.JobAction(*cfg.JobAction)
+	}
+	if cfg.FenceDaemonSets != nil {
+		opts.FenceDaemonSets = *cfg.FenceDaemonSets
+	}
+	if cfg.PauseAutoscalers != nil {
+		opts.PauseAutoscalers = *cfg.PauseAutoscalers
 	}
-	if cfg.StorageClass != nil {
-		filter.StorageClass = *cfg.StorageClass
+	if cfg.ArgoCDNamespace != nil {
+		opts.ArgoCDNamespace = *cfg.ArgoCDNamespace
 	}
+	opts.Paging = cfg.paging()
+	opts.HandlerRules = cfg.HandlerRules
+	return opts
+}
 
-	cfg.logger.Info("Finding volumes...")
+// paging returns how list calls page through results.
+func (cfg *ConfigFlags) paging() kube.Paging {
+	var paging kube.Paging
+	if cfg.PageSize != nil {
+		paging.PageSize = *cfg.PageSize
+	}
+	if cfg.FromCache != nil {
+		paging.FromCache = *cfg.FromCache
+	}
+	return paging
+}
+
+// findPVCs finds the PVCs selected by the config, grouped by namespace. With checkProtection, the protected PVCs
+// among them are returned too, which are found as they're listed when selecting PVCs by filters.
+func findPVCs(ctx context.Context, cfg *ConfigFlags, finder discovery.Finder,
+	checkProtection bool) (map[string][]string, []discovery.ProtectedPVC, error) {
 	var pvcsPerNs map[string][]string
-	if *cfg.PVCName == "" {
-		var err error
-		pvcsPerNs, err = finder.FindPVCs(ctx, filter)
-		if err != nil {
-			return err
-		}
-		if len(pvcsPerNs) == 0 {
-			cfg.logger.Info("No matching PVCs found, nothing to do")
-			return nil
-		}
-	} else {
+	var err error
+	switch {
+	case cfg.hasPVCArgs():
+		pvcsPerNs, err = cfg.resolvePVCArgs()
+	case cfg.hasVolumeSelectors():
+		pvcsPerNs, err = finder.FindPVCsForVolumes(ctx, stringSlice(cfg.PVNames), stringSlice(cfg.VolumeHandles))
+	case *cfg.PVCName != "":
 		pvcsPerNs = map[string][]string{
 			*cfg.Namespace: {*cfg.PVCName},
 		}
+	default:
+		filter, err := cfg.pvcFilter()
+		if err != nil {
+			return nil, nil, err
+		}
+		return finder.FindPVCs(ctx, filter, stringSlice(cfg.ProtectedNamespaces))
+	}
+	if err != nil || !checkProtection {
+		return pvcsPerNs, nil, err
+	}
+
+	protected, err := finder.FindProtectedPVCs(ctx, pvcsPerNs, stringSlice(cfg.ProtectedNamespaces))
+	if err != nil {
+		return nil, nil, err
+	}
+	return pvcsPerNs, protected, nil
+}
+
+func run(ctx context.Context, cfg *ConfigFlags, clients kube.Clients) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	finder := discovery.New(clients, cfg.logger, cfg.finderOptions())
+
+	cfg.logger.Info("Finding volumes...")
+	pvcsPerNs, protected, err := findPVCs(ctx, cfg, finder, true)
+	if err != nil {
+		return err
+	}
+	if len(pvcsPerNs) == 0 {
+		cfg.logger.Info("No matching PVCs found, nothing to do")
+		return nil
+	}
+	excluded := excludeProtectedPVCs(cfg, pvcsPerNs, protected)
+	if len(pvcsPerNs) == 0 {
+		cfg.logger.Info("All matching PVCs are protected, nothing to do")
+		return cfg.printPlan(newPlan(pvcsPerNs, nil, nil).withExclusions(excluded))
 	}
 
 	cfg.logger.Info("Finding pods...")
-	pods, err := finder.FindPodsUsingPVCs(ctx, pvcsPerNs)
+	podIndex, err := finder.IndexPods(ctx, pvcsPerNs)
 	if err != nil {
 		return err
 	}
+	pods := podIndex.FindPodsUsingPVCs(pvcsPerNs)
 	if len(pods) == 0 {
 		cfg.logger.Info("No pods found, nothing to do")
-		return nil
+		return cfg.printPlan(newPlan(pvcsPerNs, nil, nil).withExclusions(excluded))
 	}
 	cfg.logger.Info("Found %d pods to scale down", len(pods))
 
-	controllers, err := finder.FindControllers(ctx, pods)
+	podControllers, ownerChains, err := finder.FindControllers(ctx, pods)
 	if err != nil {
 		return err
 	}
+
+	scaler := scaling.New(clients, cfg.logger, cfg.scalingOptions())
+	objects, err := getControllerObjects(ctx, scaler, podControllers.Unique())
+	if err != nil {
+		return err
+	}
+	pods, excludedControllers := excludeProtectedControllers(cfg, objects, pvcsPerNs, pods, podControllers)
+	excluded = append(excluded, excludedControllers...)
+	warnOperatorManaged(cfg, scaler, podControllers, ownerChains)
+	controllers := podControllers.Unique()
 	if len(controllers) == 0 {
 		cfg.logger.Info("No controllers found to scale down")
-		return nil
+		return cfg.printPlan(newPlan(pvcsPerNs, pods, podControllers).withOwners(ownerChains).withExclusions(excluded))
 	}
 	cfg.logger.Info("Found %d controllers to scale down", len(controllers))
 
-	// Print the affected controllers on stdout (other logs are on stderr)
+	plan := newPlan(pvcsPerNs, pods, podControllers).withOwners(ownerChains).withExclusions(excluded)
+	if err := cfg.checkLimits(plan, len(pods)); err != nil {
+		return err
+	}
+	if cfg.outputFormat() != "" {
+		if err := plan.describe(ctx, scaler, objects); err != nil {
+			return err
+		}
+	}
+
+	standalonePods := 0
 	for _, controller := range controllers {
-		_, _ = fmt.Fprintf(cfg.out, "  %v\n", controller)
+		// Print the affected controllers on stdout (other logs are on stderr), unless the plan is printed instead
+		if cfg.outputFormat() == "" {
+			_, _ = fmt.Fprintf(cfg.out, "  %v\n", controller)
+		} else {
+			cfg.logger.Info("  %v", controller)
+		}
+		if controller.BuiltinKind() == common.KindPod {
+			standalonePods++
+		}
+	}
+	if standalonePods > 0 {
+		cfg.logger.Warn("%d standalone pod(s) will be deleted and cannot be restored afterwards", standalonePods)
+	}
+
+	gitOps := findGitOpsOwners(ctx, scaler, controllers, objects)
+	plan.setManagedBy(gitOps)
+	if err := cfg.checkGitOps(gitOps, controllers); err != nil {
+		return err
+	}
+	if err := preflight(ctx, cfg, clients, scaler, controllers, gitOps); err != nil {
+		return err
 	}
 
 	skipConfirmation := cfg.Confirmed != nil && *cfg.Confirmed
-	confirmed, err := confirmAction(cfg.logger, "Scale down the controllers listed above?", skipConfirmation)
+	confirmed, err := confirmAction(ctx, cfg.logger, cfg.in, "Scale down the controllers listed above?", skipConfirmation)
 	if err != nil {
 		return err
 	}
 	if !confirmed {
 		cfg.logger.Info("Operation cancelled by user")
-		return nil
+		return cfg.printPlan(plan)
+	}
+	cfg.startTimeout()
+
+	suspended, err := suspendGitOps(ctx, cfg, scaler, gitOps)
+	if err != nil {
+		return cfg.abort(ctx, scaler, plan, suspended, err)
 	}
 
 	cfg.logger.Info("Scaling down %d controller(s)...", len(controllers))
-	scaler := scaling.New(clientset, cfg.logger, *cfg.DryRun)
-	errors := 0
-	for _, ctrl := range controllers {
-		if err := scaler.ScaleDown(ctx, ctrl); err != nil {
-			cfg.logger.Error(err)
-			errors++
+	var errors atomic.Int32
+	forEachInOrder(cfg.logger, len(controllers), cfg.parallelism(), func() bool {
+		// Stop at the first failure with --atomic, since everything will be rolled back anyway
+		return ctx.Err() != nil || (cfg.isAtomic() && errors.Load() > 0)
+	}, func(i int, log *logger.Logger) {
+		err := scaler.WithLogger(log).WithPVCs(plan.pvcsOf(controllers[i])).ScaleDown(ctx, controllers[i])
+		plan.Controllers[i].setResult(cfg.isDryRun(), err)
+		if err != nil {
+			log.Error(err)
+			errors.Add(1)
 			// Continue with other controllers even if one fails
 		}
-	}
+	})
 
-	if errors > 0 {
-		return fmt.Errorf("encountered %d errors scaling down", errors)
+	if ctx.Err() != nil {
+		reportMounted(cfg, podIndex, pvcsPerNs)
+		return cfg.abort(ctx, scaler, plan, suspended, context.Cause(ctx))
+	}
+	if errors.Load() > 0 {
+		return cfg.abort(ctx, scaler, plan, suspended, fmt.Errorf("encountered %d errors scaling down", errors.Load()))
 	}
 
-	if !*cfg.DryRun {
-		<-spinner.Wait("Waiting for pods to scale down... ", func() (bool, error) {
-			pods, err := finder.FindPodsUsingPVCs(ctx, pvcsPerNs)
-			if err != nil {
-				return false, err
-			}
-			return len(pods) == 0, nil
+	if !cfg.isDryRun() {
+		// The pod index is kept up to date by a watch, so it's checked whenever a pod changes rather than polled
+		err := <-spinner.WaitForEvents(ctx, "Waiting for pods to scale down... ", func() (bool, string, error) {
+			pods := podIndex.FindPodsUsingPVCs(pvcsPerNs)
+			return len(pods) == 0, fmt.Sprintf(" %d pod(s) remaining", len(pods)), nil
 		}, func(err error) {
 			cfg.logger.Error(err)
-		}, 2*time.Second)
+		}, podIndex.Changed())
+		if err != nil {
+			reportMounted(cfg, podIndex, pvcsPerNs)
+			return cfg.abort(ctx, scaler, plan, suspended, err)
+		}
+
+		if cfg.WaitForDetach != nil && *cfg.WaitForDetach {
+			if err := waitForDetach(ctx, cfg, finder, pvcsPerNs, pods); err != nil {
+				return cfg.abort(ctx, scaler, plan, suspended, err)
+			}
+		}
 	}
 
+	if err := cfg.printPlan(plan); err != nil {
+		return err
+	}
 	cfg.logger.Info("Scale down complete")
 
 	return nil
 }
 
+// controllerObjects are the controllers found, each looked up once to be checked for protection, handler rules
+// and GitOps owners.
+type controllerObjects map[common.ControllerRef]*unstructured.Unstructured
+
+func getControllerObjects(ctx context.Context, scaler scaling.Scaler, controllers []common.ControllerRef) (controllerObjects, error) {
+	objects := controllerObjects{}
+	for _, ctrl := range controllers {
+		obj, err := scaler.GetObject(ctx, ctrl)
+		if err != nil {
+			return nil, err
+		}
+		objects[ctrl] = obj
+	}
+	return objects, nil
+}
+
+// waitForDetach waits until the PersistentVolumes bound to the given PVCs are no longer attached to any node.
+// The pods' nodes are the ones which may still report the volumes in use.
+func waitForDetach(ctx context.Context, cfg *ConfigFlags, finder discovery.Finder, pvcsPerNs map[string][]string,
+	pods []corev1.Pod) error {
+	volumes, err := finder.FindBoundVolumes(ctx, pvcsPerNs)
+	if err != nil {
+		return err
+	}
+	if len(volumes) == 0 {
+		return nil
+	}
+
+	nodeNames := make([]string, len(pods))
+	for i, pod := range pods {
+		nodeNames[i] = pod.Spec.NodeName
+	}
+	index, err := finder.IndexAttachments(ctx, volumes, nodeNames)
+	if err != nil {
+		return err
+	}
+
+	// The attachment index is kept up to date by watches, so it's checked whenever anything changes rather
+	// than polled
+	err = <-spinner.WaitForEvents(ctx, "Waiting for volumes to detach... ", func() (bool, string, error) {
+		attached := index.FindAttachedVolumes()
+		names := make([]string, len(attached))
+		for i, vol := range attached {
+			names[i] = vol.String()
+		}
+		status := fmt.Sprintf(" %d/%d detached, waiting on: %s", len(volumes)-len(attached), len(volumes),
+			strings.Join(names, ", "))
+		return len(attached) == 0, status, nil
+	}, func(err error) {
+		cfg.logger.Error(err)
+	}, index.Changed())
+	if err != nil {
+		reportAttached(cfg, index)
+		return err
+	}
+
+	cfg.logger.Info("All %d volume(s) detached", len(volumes))
+	return nil
+}
+
+// reportMounted logs the pods which are still mounting any of the given PVCs, after waiting for them
+// was interrupted. The pod index is no longer watched by then, so this is the last state seen.
+func reportMounted(cfg *ConfigFlags, podIndex *discovery.PodIndex, pvcsPerNs map[string][]string) {
+	for _, pod := range podIndex.FindPodsUsingPVCs(pvcsPerNs) {
+		pvcs := discovery.MountedPVCs(pod, pvcsPerNs[pod.Namespace])
+		cfg.logger.Warn("  Pod %s/%s is still mounting PVC(s): %s", pod.Namespace, pod.Name, strings.Join(pvcs, ", "))
+	}
+}
+
+// reportAttached logs the volumes which are still attached, after waiting for them was interrupted. The
+// attachment index is no longer watched by then, so this is the last state seen.
+func reportAttached(cfg *ConfigFlags, index *discovery.AttachmentIndex) {
+	for _, vol := range index.FindAttachedVolumes() {
+		cfg.logger.Warn("  Volume %v is still attached", vol)
+	}
+}
+
 // confirmAction prompts the user to confirm an action by typing "yes".
-// Returns true if the user confirms, false otherwise.
-func confirmAction(log *logger.Logger, prompt string, skipConfirmation bool) (bool, error) {
+// Returns true if the user confirms, false otherwise. Interrupting the plugin (or its timeout expiring)
+// while it's waiting for input returns the context's error.
+func confirmAction(ctx context.Context, log *logger.Logger, in io.Reader, prompt string, skipConfirmation bool) (bool, error) {
 	if skipConfirmation {
 		return true, nil
 	}
 
 	log.Instructions("%s\nType 'yes' to continue: ", prompt)
 
-	reader := bufio.NewReader(os.Stdin)
-	response, err := reader.ReadString('\n')
-	if err != nil {
-		return false, fmt.Errorf("failed to read user input: %w", err)
+	// Reading can't be cancelled, so it's left blocked in the background if the context is done first
+	type result struct {
+		response string
+		err      error
 	}
+	results := make(chan result, 1)
+	go func() {
+		response, err := bufio.NewReader(in).ReadString('\n')
+		results <- result{response, err}
+	}()
 
-	response = strings.TrimSpace(strings.ToLower(response))
-	return response == "yes", nil
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case res := <-results:
+		if res.err != nil {
+			return false, fmt.Errorf("failed to read user input: %w", res.err)
+		}
+		response := strings.TrimSpace(strings.ToLower(res.response))
+		return response == "yes", nil
+	}
 }
@@ -1,3 +1,5 @@
+//go:build e2e
+
 package plugin
 
 import (
@@ -10,6 +12,7 @@ import (
 
 	"github.com/dancavallaro/kubectl-unmount/pkg/common"
 	"github.com/dancavallaro/kubectl-unmount/pkg/logger"
+	"github.com/dancavallaro/kubectl-unmount/pkg/scaling"
 	"github.com/stretchr/testify/require"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -150,7 +153,7 @@ func TestRunPlugin(t *testing.T) {
 		Assess("Verify expected Pods are running", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
 			ns := ctx.Value("namespace").(string)
 			out, logs, err := runPlugin(ctx, func(cfg *ConfigFlags) {
-				*cfg.DryRun = true
+				*cfg.DryRun = string(scaling.DryRunClient)
 			})
 			require.NoError(t, err)
 			require.Contains(t, logs, "Found 1 pods to scale down")
@@ -161,7 +164,7 @@ func TestRunPlugin(t *testing.T) {
 		Assess("Scale down affected controllers", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
 			ns := ctx.Value("namespace").(string)
 			out, logs, err := runPlugin(ctx, func(cfg *ConfigFlags) {
-				*cfg.DryRun = false
+				*cfg.DryRun = string(scaling.DryRunNone)
 			})
 			require.NoError(t, err)
 			require.Contains(t, logs, "Scale down complete")
@@ -170,30 +173,39 @@ func TestRunPlugin(t *testing.T) {
 		}).
 		Assess("Verify Pods are no longer running", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
 			out, logs, err := runPlugin(ctx, func(cfg *ConfigFlags) {
-				*cfg.DryRun = true
+				*cfg.DryRun = string(scaling.DryRunClient)
 			})
 			require.NoError(t, err)
 			require.Contains(t, logs, "No pods found, nothing to do")
 			require.Empty(t, out)
 			return ctx
 		}).
+		Assess("Restore scaled down controllers", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+			ns := ctx.Value("namespace").(string)
+			var logBuf, outBuf bytes.Buffer
+			pluginCfg := newConfig(ctx, &logBuf, &outBuf)
+			err := RunRestore(pluginCfg)
+			require.NoError(t, err)
+			require.Contains(t, logBuf.String(), "Restored Deployment")
+			require.Equal(t, fmt.Sprintf("Deployment/%s/test-deployment", ns), strings.TrimSpace(outBuf.String()))
+
+			deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "test-deployment", Namespace: ns}}
+			err = wait.For(conditions.New(cfg.Client().Resources()).ResourceMatch(deployment, func(object k8s.Object) bool {
+				d := object.(*appsv1.Deployment)
+				_, annotated := d.Annotations[common.AnnotationOriginalReplicas]
+				return *d.Spec.Replicas == 1 && !annotated
+			}))
+			require.NoError(t, err)
+			return ctx
+		}).
 		Feature()
 
 	testenv.Test(t, f)
 }
 
 func runPlugin(ctx context.Context, configurers ...func(*ConfigFlags)) (string, string, error) {
-	ns := ctx.Value("namespace").(string)
 	var logBuf, outBuf bytes.Buffer
-	pluginCfg := &ConfigFlags{
-		PVCName:      common.StringP(""),
-		StorageClass: &storageClassName,
-		DryRun:       common.BoolP(false),
-		Confirmed:    common.BoolP(true),
-		logger:       logger.NewLogger(&logBuf),
-		out:          &outBuf,
-	}
-	pluginCfg.Namespace = &ns
+	pluginCfg := newConfig(ctx, &logBuf, &outBuf)
 
 	for _, configurer := range configurers {
 		configurer(pluginCfg)
@@ -203,3 +215,18 @@ func runPlugin(ctx context.Context, configurers ...func(*ConfigFlags)) (string,
 
 	return strings.TrimSpace(outBuf.String()), logBuf.String(), err
 }
+
+func newConfig(ctx context.Context, logBuf, outBuf *bytes.Buffer) *ConfigFlags {
+	ns := ctx.Value("namespace").(string)
+	pluginCfg := &ConfigFlags{
+		PVCName:       common.StringP(""),
+		StorageClass:  &storageClassName,
+		DryRun:        common.StringP(string(scaling.DryRunNone)),
+		Confirmed:     common.BoolP(true),
+		WaitForDetach: common.BoolP(true),
+		logger:        logger.NewLogger(logBuf),
+		out:           outBuf,
+	}
+	pluginCfg.Namespace = &ns
+	return pluginCfg
+}
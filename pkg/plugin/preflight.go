@@ -0,0 +1,130 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	"github.com/dancavallaro/kubectl-unmount/pkg/kube"
+	"github.com/dancavallaro/kubectl-unmount/pkg/scaling"
+	"golang.org/x/sync/errgroup"
+)
+
+func (cfg *ConfigFlags) skipPreflight() bool {
+	return cfg.Preflight != nil && !*cfg.Preflight
+}
+
+// preflight checks that every API request the run would make is allowed, using SelfSubjectAccessReviews, and
+// logs the results as a permission matrix. It fails before anything is changed if any permission is missing.
+func preflight(ctx context.Context, cfg *ConfigFlags, clients kube.Clients, scaler scaling.Scaler,
+	controllers []common.ControllerRef, gitOps gitOpsOwners) error {
+	if cfg.skipPreflight() {
+		return nil
+	}
+
+	perms, err := requiredPermissions(cfg, scaler, controllers, gitOps)
+	if err != nil {
+		return err
+	}
+
+	cfg.logger.Info("Checking %d permission(s)...", len(perms))
+	results := make([]kube.AccessResult, len(perms))
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(cfg.parallelism())
+	for i, perm := range perms {
+		g.Go(func() error {
+			result, err := kube.CheckAccess(ctx, clients.Kubernetes, perm)
+			results[i] = result
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	cfg.logger.Info("%s", permissionMatrix(results))
+	denied := 0
+	for _, result := range results {
+		if !result.Allowed {
+			denied++
+		}
+	}
+	if denied > 0 {
+		return fmt.Errorf("missing %d of %d required permission(s), aborting without changing anything "+
+			"(use --preflight=false to skip this check)", denied, len(perms))
+	}
+	return nil
+}
+
+// requiredPermissions returns every API request the run would make after confirmation, deduplicated and sorted.
+func requiredPermissions(cfg *ConfigFlags, scaler scaling.Scaler, controllers []common.ControllerRef,
+	gitOps gitOpsOwners) ([]kube.Permission, error) {
+	perms := make(map[kube.Permission]bool)
+	add := func(more []kube.Permission) {
+		for _, perm := range more {
+			perms[perm] = true
+		}
+	}
+
+	for _, ctrl := range controllers {
+		ctrlPerms, err := scaler.RequiredPermissions(ctrl)
+		if err != nil {
+			return nil, err
+		}
+		add(ctrlPerms)
+	}
+
+	if cfg.gitOpsMode() == GitOpsModeSuspend {
+		for _, owner := range gitOps.Unique() {
+			ownerPerms, err := scaler.GitOpsPermissions(owner)
+			if err != nil {
+				return nil, err
+			}
+			add(ownerPerms)
+		}
+	}
+
+	if cfg.WaitForDetach != nil && *cfg.WaitForDetach && !cfg.isDryRun() {
+		add([]kube.Permission{
+			{Verb: "list", Resource: "persistentvolumes"},
+			{Verb: "list", Resource: "nodes"},
+			{Verb: "watch", Resource: "nodes"},
+			{Verb: "list", Group: "storage.k8s.io", Resource: "volumeattachments"},
+			{Verb: "watch", Group: "storage.k8s.io", Resource: "volumeattachments"},
+		})
+	}
+
+	return slices.SortedFunc(maps.Keys(perms), func(a, b kube.Permission) int {
+		if c := strings.Compare(a.Namespace, b.Namespace); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.ResourceString(), b.ResourceString()); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Verb, b.Verb)
+	}), nil
+}
+
+// permissionMatrix renders the access results as a table.
+func permissionMatrix(results []kube.AccessResult) string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "NAMESPACE\tRESOURCE\tVERB\tALLOWED")
+	for _, result := range results {
+		allowed := "yes"
+		if !result.Allowed {
+			allowed = "NO"
+			if result.Reason != "" {
+				allowed += " (" + result.Reason + ")"
+			}
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", valueOrNone(result.Namespace), result.ResourceString(), result.Verb, allowed)
+	}
+	_ = w.Flush()
+	return strings.TrimSuffix(buf.String(), "\n")
+}
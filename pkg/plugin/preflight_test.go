@@ -0,0 +1,204 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	"github.com/dancavallaro/kubectl-unmount/pkg/kube"
+	"github.com/dancavallaro/kubectl-unmount/pkg/kube/kubetest"
+	"github.com/dancavallaro/kubectl-unmount/pkg/logger"
+	"github.com/dancavallaro/kubectl-unmount/pkg/scaling"
+	"github.com/stretchr/testify/require"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// withoutKEDA is a REST mapper for a cluster where KEDA isn't installed.
+type withoutKEDA struct {
+	meta.RESTMapper
+}
+
+func (m withoutKEDA) RESTMapping(gk schema.GroupKind, versions ...string) (*meta.RESTMapping, error) {
+	if gk.Group == "keda.sh" {
+		return nil, &meta.NoKindMatchError{GroupKind: gk, SearchedVersions: versions}
+	}
+	return m.RESTMapper.RESTMapping(gk, versions...)
+}
+
+func TestRequiredPermissions(t *testing.T) {
+	ref := func(apiVersion, kind string) common.ControllerRef {
+		return common.ControllerRef{APIVersion: apiVersion, Kind: kind, Namespace: testNamespace, Name: "test"}
+	}
+	deployment := ref("apps/v1", common.KindDeployment)
+	scaleDeployment := []kube.Permission{
+		{Verb: "patch", Group: "apps", Resource: "deployments", Namespace: testNamespace},
+		{Verb: "get", Group: "apps", Resource: "deployments", Subresource: "scale", Namespace: testNamespace},
+		{Verb: "update", Group: "apps", Resource: "deployments", Subresource: "scale", Namespace: testNamespace},
+	}
+	pauseHPAs := []kube.Permission{
+		{Verb: "list", Group: "autoscaling", Resource: "horizontalpodautoscalers", Namespace: testNamespace},
+		{Verb: "patch", Group: "autoscaling", Resource: "horizontalpodautoscalers", Namespace: testNamespace},
+	}
+
+	tests := []struct {
+		name        string
+		controllers []common.ControllerRef
+		configure   func(cfg *ConfigFlags)
+		withoutKEDA bool
+		want        []kube.Permission
+	}{
+		{
+			name:        "Deployment",
+			controllers: []common.ControllerRef{deployment},
+			want:        scaleDeployment,
+		},
+		{
+			name:        "StatefulSet",
+			controllers: []common.ControllerRef{ref("apps/v1", common.KindStatefulSet)},
+			want: []kube.Permission{
+				{Verb: "patch", Group: "apps", Resource: "statefulsets", Namespace: testNamespace},
+				{Verb: "get", Group: "apps", Resource: "statefulsets", Subresource: "scale", Namespace: testNamespace},
+				{Verb: "update", Group: "apps", Resource: "statefulsets", Subresource: "scale", Namespace: testNamespace},
+			},
+		},
+		{
+			name:        "standalone pod",
+			controllers: []common.ControllerRef{ref("v1", common.KindPod)},
+			want:        []kube.Permission{{Verb: "delete", Resource: "pods", Namespace: testNamespace}},
+		},
+		{
+			name:        "CronJob with its active Jobs suspended",
+			controllers: []common.ControllerRef{ref("batch/v1", common.KindCronJob)},
+			want: []kube.Permission{
+				{Verb: "get", Group: "batch", Resource: "cronjobs", Namespace: testNamespace},
+				{Verb: "patch", Group: "batch", Resource: "cronjobs", Namespace: testNamespace},
+				{Verb: "get", Group: "batch", Resource: "jobs", Namespace: testNamespace},
+				{Verb: "patch", Group: "batch", Resource: "jobs", Namespace: testNamespace},
+			},
+		},
+		{
+			name:        "CronJob with its active Jobs deleted",
+			controllers: []common.ControllerRef{ref("batch/v1", common.KindCronJob)},
+			configure:   func(cfg *ConfigFlags) { cfg.JobAction = common.StringP(string(scaling.JobActionDelete)) },
+			want: []kube.Permission{
+				{Verb: "get", Group: "batch", Resource: "cronjobs", Namespace: testNamespace},
+				{Verb: "patch", Group: "batch", Resource: "cronjobs", Namespace: testNamespace},
+				{Verb: "delete", Group: "batch", Resource: "jobs", Namespace: testNamespace},
+			},
+		},
+		{
+			name:        "Job suspended",
+			controllers: []common.ControllerRef{ref("batch/v1", common.KindJob)},
+			want: []kube.Permission{
+				{Verb: "get", Group: "batch", Resource: "jobs", Namespace: testNamespace},
+				{Verb: "patch", Group: "batch", Resource: "jobs", Namespace: testNamespace},
+			},
+		},
+		{
+			name:        "Job waited for",
+			controllers: []common.ControllerRef{ref("batch/v1", common.KindJob)},
+			configure:   func(cfg *ConfigFlags) { cfg.JobAction = common.StringP(string(scaling.JobActionWait)) },
+			want:        []kube.Permission{{Verb: "get", Group: "batch", Resource: "jobs", Namespace: testNamespace}},
+		},
+		{
+			name:        "Job deleted",
+			controllers: []common.ControllerRef{ref("batch/v1", common.KindJob)},
+			configure:   func(cfg *ConfigFlags) { cfg.JobAction = common.StringP(string(scaling.JobActionDelete)) },
+			want:        []kube.Permission{{Verb: "delete", Group: "batch", Resource: "jobs", Namespace: testNamespace}},
+		},
+		{
+			name:        "DaemonSet not fenced",
+			controllers: []common.ControllerRef{ref("apps/v1", common.KindDaemonSet)},
+			want:        nil,
+		},
+		{
+			name:        "DaemonSet fenced",
+			controllers: []common.ControllerRef{ref("apps/v1", common.KindDaemonSet)},
+			configure:   func(cfg *ConfigFlags) { cfg.FenceDaemonSets = common.BoolP(true) },
+			want: []kube.Permission{
+				{Verb: "get", Group: "apps", Resource: "daemonsets", Namespace: testNamespace},
+				{Verb: "patch", Group: "apps", Resource: "daemonsets", Namespace: testNamespace},
+			},
+		},
+		{
+			name:        "autoscalers paused with KEDA",
+			controllers: []common.ControllerRef{deployment},
+			configure:   func(cfg *ConfigFlags) { cfg.PauseAutoscalers = common.BoolP(true) },
+			want: append(append(scaleDeployment, pauseHPAs...),
+				kube.Permission{Verb: "list", Group: "keda.sh", Resource: "scaledobjects", Namespace: testNamespace},
+				kube.Permission{Verb: "patch", Group: "keda.sh", Resource: "scaledobjects", Namespace: testNamespace},
+			),
+		},
+		{
+			name:        "autoscalers paused without KEDA",
+			controllers: []common.ControllerRef{deployment},
+			configure:   func(cfg *ConfigFlags) { cfg.PauseAutoscalers = common.BoolP(true) },
+			withoutKEDA: true,
+			want:        append(scaleDeployment, pauseHPAs...),
+		},
+		{
+			name:        "deduplicated",
+			controllers: []common.ControllerRef{deployment, {APIVersion: "apps/v1", Kind: common.KindDeployment, Namespace: testNamespace, Name: "other"}},
+			want:        scaleDeployment,
+		},
+		{
+			name:        "waiting for detach",
+			controllers: []common.ControllerRef{deployment},
+			configure:   func(cfg *ConfigFlags) { cfg.WaitForDetach = common.BoolP(true) },
+			want: append([]kube.Permission{
+				{Verb: "list", Resource: "nodes"},
+				{Verb: "watch", Resource: "nodes"},
+				{Verb: "list", Resource: "persistentvolumes"},
+				{Verb: "list", Group: "storage.k8s.io", Resource: "volumeattachments"},
+				{Verb: "watch", Group: "storage.k8s.io", Resource: "volumeattachments"},
+			}, scaleDeployment...),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients := kubetest.NewClients()
+			if tt.withoutKEDA {
+				clients.Mapper = withoutKEDA{clients.Mapper}
+			}
+			cfg := &ConfigFlags{}
+			if tt.configure != nil {
+				tt.configure(cfg)
+			}
+			scaler := scaling.New(clients.Clients, logger.NewLogger(&bytes.Buffer{}), cfg.scalingOptions())
+
+			perms, err := requiredPermissions(cfg, scaler, tt.controllers, nil)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, perms)
+		})
+	}
+}
+
+func TestRunPreflightDenied(t *testing.T) {
+	clients := kubetest.NewClients(append([]runtime.Object{newTestPVC("test-pvc")}, newTestDeployment("test", "test-pvc")...)...)
+	clients.FakeClientset.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		if attrs := review.Spec.ResourceAttributes; attrs.Resource == "deployments" && attrs.Subresource == "scale" && attrs.Verb == "update" {
+			review.Status.Reason = "forbidden by test"
+			return true, review, nil
+		}
+		return false, nil, nil
+	})
+	var logBuf, outBuf bytes.Buffer
+	cfg := newTestConfig(&logBuf, &outBuf, scaling.DryRunNone)
+
+	err := run(context.Background(), cfg, clients.Clients)
+	require.EqualError(t, err, "missing 1 of 8 required permission(s), aborting without changing anything "+
+		"(use --preflight=false to skip this check)")
+	require.Contains(t, logBuf.String(), "NO (forbidden by test)")
+	require.Empty(t, writeActions(clients))
+	for _, action := range clients.FakeDynamic.Actions() {
+		require.Contains(t, []string{"get", "list", "watch"}, action.GetVerb())
+	}
+	require.Equal(t, int32(1), getReplicas(t, clients, "test"))
+}
@@ -0,0 +1,120 @@
+package plugin
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	"github.com/dancavallaro/kubectl-unmount/pkg/discovery"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// DefaultProtectedNamespaces are never unmounted unless --ignore-protection is given.
+var DefaultProtectedNamespaces = []string{"kube-system", "kube-public", "kube-node-lease"}
+
+const (
+	reasonProtectedNamespace = "namespace is protected"
+	reasonProtectAnnotation  = "annotated " + common.AnnotationProtect + "=true"
+)
+
+func (cfg *ConfigFlags) ignoreProtection() bool {
+	return cfg.IgnoreProtection != nil && *cfg.IgnoreProtection
+}
+
+// excludeProtectedPVCs removes the protected PVCs, those in protected namespaces or with the protect annotation,
+// from pvcsPerNs and returns them as exclusions. With --ignore-protection they're only warned about.
+func excludeProtectedPVCs(cfg *ConfigFlags, pvcsPerNs map[string][]string,
+	protected []discovery.ProtectedPVC) []PlanExclusion {
+	var exclusions []PlanExclusion
+	for _, pvc := range protected {
+		reason := reasonProtectAnnotation
+		if pvc.InProtectedNamespace {
+			reason = reasonProtectedNamespace
+		}
+		exclusions = append(exclusions, pvcExclusion(pvc.Namespace, pvc.Name, reason))
+	}
+
+	if cfg.applyExclusions(exclusions) {
+		for _, excl := range exclusions {
+			removePVC(pvcsPerNs, excl.Namespace, excl.Name)
+		}
+	}
+	return exclusions
+}
+
+// excludeProtectedControllers leaves out controllers with the protect annotation, along with their pods. The
+// PVCs those pods mount can't be unmounted while they're running, so they're excluded too, and so are any
+// pods left without a remaining PVC. With --ignore-protection they're only warned about.
+func excludeProtectedControllers(cfg *ConfigFlags, objects controllerObjects, pvcsPerNs map[string][]string,
+	pods []corev1.Pod, podControllers discovery.PodControllers) ([]corev1.Pod, []PlanExclusion) {
+	var exclusions []PlanExclusion
+	var protected []common.ControllerRef
+	for _, ctrl := range podControllers.Unique() {
+		if common.IsProtected(objects[ctrl]) {
+			protected = append(protected, ctrl)
+			exclusions = append(exclusions, PlanExclusion{
+				Kind:      ctrl.Kind,
+				Namespace: ctrl.Namespace,
+				Name:      ctrl.Name,
+				Reason:    reasonProtectAnnotation,
+			})
+		}
+	}
+
+	for _, pod := range pods {
+		ctrl := podControllers[pod.Namespace+"/"+pod.Name]
+		if !slices.Contains(protected, ctrl) {
+			continue
+		}
+		for _, pvc := range discovery.MountedPVCs(pod, pvcsPerNs[pod.Namespace]) {
+			excl := pvcExclusion(pod.Namespace, pvc, fmt.Sprintf("mounted by protected %v", ctrl))
+			if !slices.ContainsFunc(exclusions, func(e PlanExclusion) bool { return e.ref() == excl.ref() }) {
+				exclusions = append(exclusions, excl)
+			}
+		}
+	}
+
+	if !cfg.applyExclusions(exclusions) {
+		return pods, nil
+	}
+
+	for _, excl := range exclusions {
+		if excl.Kind == common.KindPersistentVolumeClaim {
+			removePVC(pvcsPerNs, excl.Namespace, excl.Name)
+		}
+	}
+	var remaining []corev1.Pod
+	for _, pod := range pods {
+		key := pod.Namespace + "/" + pod.Name
+		if len(discovery.MountedPVCs(pod, pvcsPerNs[pod.Namespace])) == 0 {
+			delete(podControllers, key)
+			continue
+		}
+		remaining = append(remaining, pod)
+	}
+	return remaining, exclusions
+}
+
+// applyExclusions reports the exclusions, and returns whether they should be applied (i.e. protection isn't
+// being ignored).
+func (cfg *ConfigFlags) applyExclusions(exclusions []PlanExclusion) bool {
+	for _, excl := range exclusions {
+		if cfg.ignoreProtection() {
+			cfg.logger.Warn("Ignoring protection of %s (%s)", excl.ref(), excl.Reason)
+		} else {
+			cfg.logger.Info("Excluding %s (%s)", excl.ref(), excl.Reason)
+		}
+	}
+	return !cfg.ignoreProtection()
+}
+
+func pvcExclusion(ns, name, reason string) PlanExclusion {
+	return PlanExclusion{Kind: common.KindPersistentVolumeClaim, Namespace: ns, Name: name, Reason: reason}
+}
+
+func removePVC(pvcsPerNs map[string][]string, ns, name string) {
+	pvcsPerNs[ns] = slices.DeleteFunc(pvcsPerNs[ns], func(pvc string) bool { return pvc == name })
+	if len(pvcsPerNs[ns]) == 0 {
+		delete(pvcsPerNs, ns)
+	}
+}
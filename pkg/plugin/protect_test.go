@@ -0,0 +1,121 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	"github.com/dancavallaro/kubectl-unmount/pkg/kube/kubetest"
+	"github.com/dancavallaro/kubectl-unmount/pkg/scaling"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+func TestRunExcludesProtected(t *testing.T) {
+	protect := func(obj runtime.Object) runtime.Object {
+		obj.(metav1.Object).SetAnnotations(map[string]string{common.AnnotationProtect: "true"})
+		return obj
+	}
+	newObjs := func(protectPVC, protectDeployment bool) []runtime.Object {
+		pvc := runtime.Object(newTestPVC("db-pvc"))
+		if protectPVC {
+			pvc = protect(pvc)
+		}
+		db := newTestDeployment("db", "db-pvc")
+		if protectDeployment {
+			db[0] = protect(db[0])
+		}
+		objs := append([]runtime.Object{pvc, newTestPVC("web-pvc")}, db...)
+		return append(objs, newTestDeployment("web", "web-pvc")...)
+	}
+
+	tests := []struct {
+		name         string
+		objs         []runtime.Object
+		configure    func(cfg *ConfigFlags)
+		wantExcluded []PlanExclusion
+		wantReplicas map[string]int32
+		wantLog      string
+	}{
+		{
+			name:      "protected namespace",
+			objs:      newObjs(false, false),
+			configure: func(cfg *ConfigFlags) { cfg.ProtectedNamespaces = &[]string{testNamespace} },
+			wantExcluded: []PlanExclusion{
+				{Kind: common.KindPersistentVolumeClaim, Namespace: testNamespace, Name: "db-pvc", Reason: reasonProtectedNamespace},
+				{Kind: common.KindPersistentVolumeClaim, Namespace: testNamespace, Name: "web-pvc", Reason: reasonProtectedNamespace},
+			},
+			wantReplicas: map[string]int32{"db": 1, "web": 1},
+			wantLog:      "All matching PVCs are protected, nothing to do",
+		},
+		{
+			name: "annotated PVC",
+			objs: newObjs(true, false),
+			wantExcluded: []PlanExclusion{
+				{Kind: common.KindPersistentVolumeClaim, Namespace: testNamespace, Name: "db-pvc", Reason: reasonProtectAnnotation},
+			},
+			wantReplicas: map[string]int32{"db": 1, "web": 0},
+			wantLog:      "Excluding PersistentVolumeClaim/test-ns/db-pvc",
+		},
+		{
+			name: "annotated controller",
+			objs: newObjs(false, true),
+			wantExcluded: []PlanExclusion{
+				{Kind: common.KindDeployment, Namespace: testNamespace, Name: "db", Reason: reasonProtectAnnotation},
+				{
+					Kind:      common.KindPersistentVolumeClaim,
+					Namespace: testNamespace,
+					Name:      "db-pvc",
+					Reason:    "mounted by protected Deployment/test-ns/db",
+				},
+			},
+			wantReplicas: map[string]int32{"db": 1, "web": 0},
+			wantLog:      "Excluding Deployment/test-ns/db",
+		},
+		{
+			name:         "--ignore-protection",
+			objs:         newObjs(false, true),
+			configure:    func(cfg *ConfigFlags) { cfg.IgnoreProtection = common.BoolP(true) },
+			wantReplicas: map[string]int32{"db": 0, "web": 0},
+			wantLog:      "Ignoring protection of Deployment/test-ns/db",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients := kubetest.NewClients(tt.objs...)
+			var logBuf, outBuf bytes.Buffer
+			// The fake clientset ignores dryRun, so what's scaled down can be checked without waiting for pods
+			cfg := newTestConfig(&logBuf, &outBuf, scaling.DryRunServer)
+			cfg.PrintFlags = genericclioptions.NewPrintFlags("").WithDefaultOutput("json")
+			if tt.configure != nil {
+				tt.configure(cfg)
+			}
+
+			require.NoError(t, run(context.Background(), cfg, clients.Clients))
+			require.Contains(t, logBuf.String(), tt.wantLog)
+			var plan Plan
+			require.NoError(t, json.Unmarshal(outBuf.Bytes(), &plan))
+			require.Equal(t, tt.wantExcluded, plan.Excluded)
+			for name, replicas := range tt.wantReplicas {
+				require.Equal(t, replicas, getReplicas(t, clients, name), "replicas of %s", name)
+			}
+
+			// Each controller is looked up once while following its pods' owner chains, then once more for the
+			// protection, plan and GitOps checks, which share the object
+			gets := map[string]int{}
+			for _, action := range clients.FakeDynamic.Actions() {
+				if action.GetVerb() == "get" && action.GetResource().Resource == "deployments" {
+					gets[action.(interface{ GetName() string }).GetName()]++
+				}
+			}
+			for name, count := range gets {
+				require.LessOrEqual(t, count, 2, "lookups of %s", name)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/discovery"
+	"github.com/dancavallaro/kubectl-unmount/pkg/kube"
+	"github.com/dancavallaro/kubectl-unmount/pkg/scaling"
+)
+
+// RunRestore scales controllers that were previously scaled down back to their original replica counts.
+func RunRestore(pluginCfg *ConfigFlags) error {
+	clients, err := pluginCfg.init()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := pluginCfg.newContext()
+	defer cancel()
+	return pluginCfg.contextError(ctx, restore(ctx, pluginCfg, clients))
+}
+
+func restore(ctx context.Context, cfg *ConfigFlags, clients kube.Clients) error {
+	if err := cfg.validateDryRun(); err != nil {
+		return err
+	}
+	finder := discovery.New(clients, cfg.logger, cfg.finderOptions())
+
+	cfg.logger.Info("Finding volumes...")
+	pvcsPerNs, _, err := findPVCs(ctx, cfg, finder, false)
+	if err != nil {
+		return err
+	}
+	if len(pvcsPerNs) == 0 {
+		cfg.logger.Info("No matching PVCs found, nothing to do")
+		return nil
+	}
+
+	cfg.logger.Info("Finding scaled down controllers...")
+	controllers, err := finder.FindScaledDownControllers(ctx, pvcsPerNs)
+	if err != nil {
+		return err
+	}
+	scaler := scaling.New(clients, cfg.logger, cfg.scalingOptions())
+	operators, err := scaler.FindQuiescedOperators(ctx, pvcsPerNs)
+	if err != nil {
+		return err
+	}
+	ruleQuiesced, err := scaler.FindRuleQuiescedResources(ctx, pvcsPerNs)
+	if err != nil {
+		return err
+	}
+	virtualMachines, err := scaler.FindHaltedVirtualMachines(ctx, pvcsPerNs)
+	if err != nil {
+		return err
+	}
+	controllers = append(controllers, virtualMachines...)
+	// Operators are resumed after the controllers they manage, like GitOps owners
+	controllers = append(controllers, ruleQuiesced...)
+	controllers = append(controllers, operators...)
+	if len(controllers) == 0 {
+		cfg.logger.Info("No controllers found to restore")
+		return nil
+	}
+	cfg.logger.Info("Found %d controllers to restore", len(controllers))
+
+	// Print the affected controllers on stdout (other logs are on stderr)
+	for _, controller := range controllers {
+		_, _ = fmt.Fprintf(cfg.out, "  %v\n", controller)
+	}
+
+	skipConfirmation := cfg.Confirmed != nil && *cfg.Confirmed
+	confirmed, err := confirmAction(ctx, cfg.logger, cfg.in, "Restore the controllers listed above?", skipConfirmation)
+	if err != nil {
+		return err
+	}
+	if !confirmed {
+		cfg.logger.Info("Operation cancelled by user")
+		return nil
+	}
+	cfg.startTimeout()
+
+	cfg.logger.Info("Restoring %d controller(s)...", len(controllers))
+	errors := 0
+	for _, ctrl := range controllers {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := scaler.Restore(ctx, ctrl); err != nil {
+			cfg.logger.Error(err)
+			errors++
+			// Continue with other controllers even if one fails
+		}
+	}
+
+	// GitOps owners are resumed last, so that they don't reconcile controllers which are still scaled down
+	objects, err := getControllerObjects(ctx, scaler, controllers)
+	if err != nil {
+		return err
+	}
+	gitOps := findGitOpsOwners(ctx, scaler, controllers, objects)
+	errors += resumeGitOps(ctx, cfg, scaler, gitOps.Unique())
+
+	if errors > 0 {
+		return fmt.Errorf("encountered %d errors restoring", errors)
+	}
+
+	cfg.logger.Info("Restore complete")
+
+	return nil
+}
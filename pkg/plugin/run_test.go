@@ -0,0 +1,261 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	"github.com/dancavallaro/kubectl-unmount/pkg/kube/kubetest"
+	"github.com/dancavallaro/kubectl-unmount/pkg/logger"
+	"github.com/dancavallaro/kubectl-unmount/pkg/scaling"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/utils/ptr"
+)
+
+const testNamespace = "test-ns"
+
+func newTestPVC(name string) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace},
+		Spec:       corev1.PersistentVolumeClaimSpec{StorageClassName: ptr.To("standard")},
+	}
+}
+
+func newTestPod(name, pvc string, owners ...metav1.OwnerReference) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace, OwnerReferences: owners},
+		Spec: corev1.PodSpec{Volumes: []corev1.Volume{{
+			Name: "data",
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: pvc},
+			},
+		}}},
+	}
+}
+
+// newTestDeployment creates a Deployment, along with its ReplicaSet and a pod mounting the PVC.
+func newTestDeployment(name, pvc string) []runtime.Object {
+	return []runtime.Object{
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace},
+			Spec:       appsv1.DeploymentSpec{Replicas: ptr.To[int32](1)},
+		},
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+			Name:            name + "-rs",
+			Namespace:       testNamespace,
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: common.KindDeployment, Name: name, Controller: ptr.To(true)}},
+		}},
+		newTestPod(name+"-pod", pvc, metav1.OwnerReference{APIVersion: "apps/v1", Kind: common.KindReplicaSet, Name: name + "-rs", Controller: ptr.To(true)}),
+	}
+}
+
+func newTestConfig(logBuf, outBuf *bytes.Buffer, dryRun scaling.DryRunMode) *ConfigFlags {
+	cfg := &ConfigFlags{
+		PVCName:       common.StringP(""),
+		StorageClass:  common.StringP("standard"),
+		DryRun:        common.StringP(string(dryRun)),
+		Confirmed:     common.BoolP(true),
+		WaitForDetach: common.BoolP(true),
+		logger:        logger.NewLogger(logBuf),
+		out:           outBuf,
+	}
+	cfg.Namespace = common.StringP(testNamespace)
+	return cfg
+}
+
+func runWithClients(t *testing.T, clients kubetest.Clients, dryRun scaling.DryRunMode) (string, string, error) {
+	var logBuf, outBuf bytes.Buffer
+	cfg := newTestConfig(&logBuf, &outBuf, dryRun)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	err := run(ctx, cfg, clients.Clients)
+	return strings.TrimSpace(outBuf.String()), logBuf.String(), err
+}
+
+// writeActions returns the actions which would have changed anything.
+func writeActions(clients kubetest.Clients) []k8stesting.Action {
+	var actions []k8stesting.Action
+	for _, action := range clients.FakeClientset.Actions() {
+		switch action.GetVerb() {
+		case "update", "patch", "delete":
+			actions = append(actions, action)
+		}
+	}
+	return actions
+}
+
+func getReplicas(t *testing.T, clients kubetest.Clients, name string) int32 {
+	deployment, err := clients.FakeClientset.AppsV1().Deployments(testNamespace).Get(context.Background(), name, metav1.GetOptions{})
+	require.NoError(t, err)
+	return *deployment.Spec.Replicas
+}
+
+func TestRunNothingToDo(t *testing.T) {
+	tests := []struct {
+		name    string
+		objs    []runtime.Object
+		wantLog string
+	}{
+		{
+			name:    "no matching PVCs",
+			objs:    []runtime.Object{newTestPod("test-pod", "other-pvc")},
+			wantLog: "No matching PVCs found, nothing to do",
+		},
+		{
+			name:    "no pods mounting the PVCs",
+			objs:    []runtime.Object{newTestPVC("test-pvc"), newTestPod("test-pod", "other-pvc")},
+			wantLog: "No pods found, nothing to do",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients := kubetest.NewClients(tt.objs...)
+			out, logs, err := runWithClients(t, clients, scaling.DryRunNone)
+			require.NoError(t, err)
+			require.Contains(t, logs, tt.wantLog)
+			require.Empty(t, out)
+			require.Empty(t, writeActions(clients))
+		})
+	}
+}
+
+func TestRunDryRun(t *testing.T) {
+	objs := append(newTestDeployment("test-deployment", "test-pvc"), newTestPVC("test-pvc"))
+	clients := kubetest.NewClients(objs...)
+
+	out, logs, err := runWithClients(t, clients, scaling.DryRunClient)
+	require.NoError(t, err)
+	require.Contains(t, logs, "Found 1 pods to scale down")
+	require.Contains(t, logs, "Found 1 controllers to scale down")
+	require.Equal(t, "Deployment/test-ns/test-deployment", out)
+	require.Empty(t, writeActions(clients))
+	require.Equal(t, int32(1), getReplicas(t, clients, "test-deployment"))
+}
+
+func TestRunServerDryRun(t *testing.T) {
+	objs := append(newTestDeployment("test-deployment", "test-pvc"),
+		newTestPVC("test-pvc"), newTestPod("test-pod", "test-pvc"))
+	clients := kubetest.NewClients(objs...)
+
+	_, logs, err := runWithClients(t, clients, scaling.DryRunServer)
+	require.NoError(t, err)
+	require.Contains(t, logs, "Found 2 controllers to scale down")
+
+	// The fake clientset makes the changes anyway, so check that every one was sent as a dry run
+	actions := writeActions(clients)
+	require.Len(t, actions, 3)
+	for _, action := range actions {
+		var dryRun []string
+		switch action := action.(type) {
+		case k8stesting.PatchActionImpl:
+			dryRun = action.GetPatchOptions().DryRun
+		case k8stesting.UpdateActionImpl:
+			dryRun = action.GetUpdateOptions().DryRun
+		case k8stesting.DeleteActionImpl:
+			dryRun = action.GetDeleteOptions().DryRun
+		}
+		require.Equal(t, []string{metav1.DryRunAll}, dryRun, "%s %s", action.GetVerb(), action.GetResource().Resource)
+	}
+}
+
+func TestRunDeletesStandalonePod(t *testing.T) {
+	clients := kubetest.NewClients(newTestPVC("test-pvc"), newTestPod("test-pod", "test-pvc"))
+
+	out, logs, err := runWithClients(t, clients, scaling.DryRunNone)
+	require.NoError(t, err)
+	require.Contains(t, logs, "1 standalone pod(s) will be deleted and cannot be restored afterwards")
+	require.Contains(t, logs, "Deleted standalone Pod test-ns/test-pod")
+	require.Contains(t, logs, "Scale down complete")
+	require.Equal(t, "Pod/test-ns/test-pod", out)
+
+	_, err = clients.FakeClientset.CoreV1().Pods(testNamespace).Get(context.Background(), "test-pod", metav1.GetOptions{})
+	require.True(t, apierrors.IsNotFound(err))
+}
+
+func TestRunAggregatesErrors(t *testing.T) {
+	objs := append(newTestDeployment("db", "db-pvc"), newTestPVC("db-pvc"))
+	objs = append(objs, newTestDeployment("web", "web-pvc")...)
+	objs = append(objs, newTestPVC("web-pvc"))
+	clients := kubetest.NewClients(objs...)
+	clients.FakeClientset.PrependReactor("update", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "scale" {
+			return false, nil, nil
+		}
+		if action.(k8stesting.UpdateAction).GetObject().(*autoscalingv1.Scale).Name != "web" {
+			return false, nil, nil
+		}
+		return true, nil, errors.New("scale denied")
+	})
+
+	_, logs, err := runWithClients(t, clients, scaling.DryRunNone)
+	require.EqualError(t, err, "encountered 1 errors scaling down")
+	require.Contains(t, logs, "failed to scale down Deployment test-ns/web: scale denied")
+	require.NotContains(t, logs, "Scale down complete")
+
+	// Other controllers are still scaled down after one fails
+	require.Equal(t, int32(0), getReplicas(t, clients, "db"))
+	require.Equal(t, int32(1), getReplicas(t, clients, "web"))
+}
+
+func TestConfirmAction(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		wantConfirmed bool
+		wantErr       bool
+	}{
+		{name: "yes", input: "yes\n", wantConfirmed: true},
+		{name: "yes in capitals", input: " YES \n", wantConfirmed: true},
+		{name: "no", input: "no\n"},
+		{name: "end of input", input: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			confirmed, err := confirmAction(context.Background(), logger.NewLogger(io.Discard), strings.NewReader(tt.input), "Proceed?", false)
+			require.Equal(t, tt.wantErr, err != nil)
+			require.Equal(t, tt.wantConfirmed, confirmed)
+		})
+	}
+}
+
+func TestConfirmActionInterrupted(t *testing.T) {
+	// Nothing is ever written to the pipe, like a user who doesn't answer before pressing Ctrl-C
+	in, _ := io.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	confirmed, err := confirmAction(ctx, logger.NewLogger(io.Discard), in, "Proceed?", false)
+	require.ErrorIs(t, err, context.Canceled)
+	require.False(t, confirmed)
+}
+
+func TestRunInterruptedWhileScalingDown(t *testing.T) {
+	objs := append(newTestDeployment("db", "db-pvc"), newTestPVC("db-pvc"))
+	clients := kubetest.NewClients(objs...)
+	var logBuf, outBuf bytes.Buffer
+	cfg := newTestConfig(&logBuf, &outBuf, scaling.DryRunNone)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	clients.FakeClientset.PrependReactor("update", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		cancel()
+		return true, nil, context.Canceled
+	})
+
+	err := run(ctx, cfg, clients.Clients)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Contains(t, logBuf.String(), "Pod test-ns/db-pod is still mounting PVC(s): db-pvc")
+}
@@ -0,0 +1,247 @@
+package scaling
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	"github.com/dancavallaro/kubectl-unmount/pkg/kube"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	kindHorizontalPodAutoscaler = "HorizontalPodAutoscaler"
+	kindScaledObject            = "ScaledObject"
+
+	// kedaPausedReplicasAnnotation makes KEDA scale the target to a fixed replica count, and stop autoscaling
+	kedaPausedReplicasAnnotation = "autoscaling.keda.sh/paused-replicas"
+	// pausedTargetSuffix is appended to the scaleTargetRef name of a paused HPA, so that it targets nothing
+	pausedTargetSuffix = "-paused-by-unmount"
+)
+
+var scaledObjectsResource = schema.GroupVersionResource{Group: "keda.sh", Version: "v1alpha1", Resource: "scaledobjects"}
+
+// scaleTarget is the controller targeted by an autoscaler.
+type scaleTarget struct {
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+}
+
+func (t scaleTarget) matches(ctrl common.ControllerRef) bool {
+	if t.Kind != ctrl.Kind || t.Name != ctrl.Name {
+		return false
+	}
+	if t.APIVersion == "" || ctrl.APIVersion == "" {
+		return true
+	}
+	// The version isn't relevant, and may legitimately differ from the owner reference's
+	gv, err := schema.ParseGroupVersion(t.APIVersion)
+	return err == nil && gv.Group == ctrl.GroupVersionKind().Group
+}
+
+// FindAutoscalers returns the names (as "Kind/name") of the autoscalers targeting the controller.
+func (s Scaler) FindAutoscalers(ctx context.Context, ctrl common.ControllerRef) ([]string, error) {
+	var names []string
+
+	hpas, err := s.findHPAs(ctx, ctrl, false)
+	if err != nil {
+		return nil, err
+	}
+	for _, hpa := range hpas {
+		names = append(names, kindHorizontalPodAutoscaler+"/"+hpa.Name)
+	}
+
+	scaledObjects, err := s.findScaledObjects(ctx, ctrl, false)
+	if err != nil {
+		return nil, err
+	}
+	for _, so := range scaledObjects {
+		names = append(names, kindScaledObject+"/"+so.GetName())
+	}
+
+	return names, nil
+}
+
+// pauseAutoscalersFor pauses the autoscalers targeting the controller. HPAs have no way of being paused, so
+// they're neutralised by pointing their scaleTargetRef at a controller which doesn't exist. KEDA
+// ScaledObjects are paused at 0 replicas with the paused-replicas annotation.
+func (s Scaler) pauseAutoscalersFor(ctx context.Context, ctrl common.ControllerRef) error {
+	hpas, err := s.findHPAs(ctx, ctrl, false)
+	if err != nil {
+		return err
+	}
+	for _, hpa := range hpas {
+		original, _ := json.Marshal(scaleTarget{
+			APIVersion: hpa.Spec.ScaleTargetRef.APIVersion,
+			Kind:       hpa.Spec.ScaleTargetRef.Kind,
+			Name:       hpa.Spec.ScaleTargetRef.Name,
+		})
+		patch := mergePatch(
+			map[string]any{common.AnnotationOriginalScaleTarget: string(original)},
+			map[string]any{"scaleTargetRef": map[string]any{"name": hpa.Spec.ScaleTargetRef.Name + pausedTargetSuffix}},
+		)
+		_, err := s.clientset.AutoscalingV2().HorizontalPodAutoscalers(ctrl.Namespace).Patch(
+			ctx, hpa.Name, types.MergePatchType, patch, s.patchOptions())
+		if err != nil {
+			return fmt.Errorf("failed to pause HorizontalPodAutoscaler %s/%s: %w", ctrl.Namespace, hpa.Name, err)
+		}
+		s.log.Info("  Paused HorizontalPodAutoscaler %s/%s", ctrl.Namespace, hpa.Name)
+	}
+
+	scaledObjects, err := s.findScaledObjects(ctx, ctrl, false)
+	if err != nil {
+		return err
+	}
+	for _, so := range scaledObjects {
+		// Record the previous paused-replicas value (if any), so that it can be restored
+		original, paused := so.GetAnnotations()[kedaPausedReplicasAnnotation]
+		var originalValue any = original
+		if !paused {
+			originalValue = ""
+		}
+		patch := mergePatch(map[string]any{
+			common.AnnotationOriginalPausedReplicas: originalValue,
+			kedaPausedReplicasAnnotation:            "0",
+		}, nil)
+		_, err := s.dynamic.Resource(scaledObjectsResource).Namespace(ctrl.Namespace).Patch(
+			ctx, so.GetName(), types.MergePatchType, patch, s.patchOptions())
+		if err != nil {
+			return fmt.Errorf("failed to pause ScaledObject %s/%s: %w", ctrl.Namespace, so.GetName(), err)
+		}
+		s.log.Info("  Paused ScaledObject %s/%s", ctrl.Namespace, so.GetName())
+	}
+
+	return nil
+}
+
+// resumeAutoscalersFor resumes the autoscalers which were paused for the controller.
+func (s Scaler) resumeAutoscalersFor(ctx context.Context, ctrl common.ControllerRef) error {
+	hpas, err := s.findHPAs(ctx, ctrl, true)
+	if err != nil {
+		return err
+	}
+	for _, hpa := range hpas {
+		var original scaleTarget
+		if err := json.Unmarshal([]byte(hpa.Annotations[common.AnnotationOriginalScaleTarget]), &original); err != nil {
+			return fmt.Errorf("invalid %s annotation on HorizontalPodAutoscaler %s/%s: %w",
+				common.AnnotationOriginalScaleTarget, ctrl.Namespace, hpa.Name, err)
+		}
+		patch := mergePatch(
+			map[string]any{common.AnnotationOriginalScaleTarget: nil},
+			map[string]any{"scaleTargetRef": original},
+		)
+		_, err := s.clientset.AutoscalingV2().HorizontalPodAutoscalers(ctrl.Namespace).Patch(
+			ctx, hpa.Name, types.MergePatchType, patch, s.patchOptions())
+		if err != nil {
+			return fmt.Errorf("failed to resume HorizontalPodAutoscaler %s/%s: %w", ctrl.Namespace, hpa.Name, err)
+		}
+		s.log.Info("  Resumed HorizontalPodAutoscaler %s/%s", ctrl.Namespace, hpa.Name)
+	}
+
+	scaledObjects, err := s.findScaledObjects(ctx, ctrl, true)
+	if err != nil {
+		return err
+	}
+	for _, so := range scaledObjects {
+		var pausedReplicas any
+		if original := so.GetAnnotations()[common.AnnotationOriginalPausedReplicas]; original != "" {
+			pausedReplicas = original
+		}
+		patch := mergePatch(map[string]any{
+			common.AnnotationOriginalPausedReplicas: nil,
+			kedaPausedReplicasAnnotation:            pausedReplicas,
+		}, nil)
+		_, err := s.dynamic.Resource(scaledObjectsResource).Namespace(ctrl.Namespace).Patch(
+			ctx, so.GetName(), types.MergePatchType, patch, s.patchOptions())
+		if err != nil {
+			return fmt.Errorf("failed to resume ScaledObject %s/%s: %w", ctrl.Namespace, so.GetName(), err)
+		}
+		s.log.Info("  Resumed ScaledObject %s/%s", ctrl.Namespace, so.GetName())
+	}
+
+	return nil
+}
+
+// findHPAs finds the HPAs targeting the controller. If paused is true, it finds the HPAs which were paused
+// by unmount and originally targeted the controller instead.
+func (s Scaler) findHPAs(ctx context.Context, ctrl common.ControllerRef, paused bool) ([]autoscalingv2.HorizontalPodAutoscaler, error) {
+	var hpas []autoscalingv2.HorizontalPodAutoscaler
+	hpaClient := s.clientset.AutoscalingV2().HorizontalPodAutoscalers(ctrl.Namespace)
+	err := kube.EachListItem(ctx, s.paging, metav1.ListOptions{},
+		func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+			return hpaClient.List(ctx, opts)
+		}, func(hpa *autoscalingv2.HorizontalPodAutoscaler) error {
+			// HPAs managed by KEDA are paused through their ScaledObject
+			if owner := metav1.GetControllerOf(hpa); owner != nil && owner.Kind == kindScaledObject {
+				return nil
+			}
+
+			original, isPaused := hpa.Annotations[common.AnnotationOriginalScaleTarget]
+			if isPaused != paused {
+				return nil
+			}
+			target := scaleTarget{
+				APIVersion: hpa.Spec.ScaleTargetRef.APIVersion,
+				Kind:       hpa.Spec.ScaleTargetRef.Kind,
+				Name:       hpa.Spec.ScaleTargetRef.Name,
+			}
+			if paused && json.Unmarshal([]byte(original), &target) != nil {
+				return nil
+			}
+			if target.matches(ctrl) {
+				hpas = append(hpas, *hpa)
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list horizontal pod autoscalers: %w", err)
+	}
+	return hpas, nil
+}
+
+// findScaledObjects finds the KEDA ScaledObjects targeting the controller, if KEDA is installed. If paused
+// is true, only ScaledObjects paused by unmount are returned, otherwise only those which weren't.
+func (s Scaler) findScaledObjects(ctx context.Context, ctrl common.ControllerRef, paused bool) ([]unstructured.Unstructured, error) {
+	var scaledObjects []unstructured.Unstructured
+	resource := s.dynamic.Resource(scaledObjectsResource).Namespace(ctrl.Namespace)
+	err := kube.EachListItem(ctx, s.paging, metav1.ListOptions{},
+		func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+			return resource.List(ctx, opts)
+		}, func(so *unstructured.Unstructured) error {
+			if _, isPaused := so.GetAnnotations()[common.AnnotationOriginalPausedReplicas]; isPaused != paused {
+				return nil
+			}
+			target := scaleTarget{
+				// KEDA defaults to targeting a Deployment
+				APIVersion: "apps/v1",
+				Kind:       common.KindDeployment,
+			}
+			ref, _, _ := unstructured.NestedStringMap(so.Object, "spec", "scaleTargetRef")
+			if ref["apiVersion"] != "" {
+				target.APIVersion = ref["apiVersion"]
+			}
+			if ref["kind"] != "" {
+				target.Kind = ref["kind"]
+			}
+			target.Name = ref["name"]
+			if target.matches(ctrl) {
+				scaledObjects = append(scaledObjects, *so)
+			}
+			return nil
+		})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list scaled objects: %w", err)
+	}
+	return scaledObjects, nil
+}
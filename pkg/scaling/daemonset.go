@@ -0,0 +1,92 @@
+package scaling
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// fenceNodeSelectorKey is added to a DaemonSet's nodeSelector so that it doesn't match any node
+	fenceNodeSelectorKey   = "unmount.kubectl.io/fence"
+	fenceNodeSelectorValue = "unschedulable"
+)
+
+// fenceDaemonSet stops all of a DaemonSet's pods by adding a nodeSelector which no node matches. The
+// original pod template is saved in an annotation, so it can be restored exactly.
+func (s Scaler) fenceDaemonSet(ctx context.Context, ctrl common.ControllerRef) error {
+	daemonSets := s.clientset.AppsV1().DaemonSets(ctrl.Namespace)
+	ds, err := daemonSets.Get(ctx, ctrl.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get %s %s/%s: %w", ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
+	}
+
+	if _, ok := ds.Annotations[common.AnnotationOriginalTemplate]; ok {
+		s.log.Info("%s %s/%s is already fenced", ctrl.Kind, ctrl.Namespace, ctrl.Name)
+		return nil
+	}
+
+	template, err := json.Marshal(ds.Spec.Template)
+	if err != nil {
+		return fmt.Errorf("failed to save template of %s %s/%s: %w", ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
+	}
+
+	patch := mergePatch(
+		map[string]any{common.AnnotationOriginalTemplate: string(template)},
+		map[string]any{
+			"template": map[string]any{
+				"spec": map[string]any{
+					"nodeSelector": map[string]any{fenceNodeSelectorKey: fenceNodeSelectorValue},
+				},
+			},
+		},
+	)
+	if _, err := daemonSets.Patch(ctx, ctrl.Name, types.MergePatchType, patch, s.patchOptions()); err != nil {
+		return fmt.Errorf("failed to fence %s %s/%s: %w", ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
+	}
+
+	s.log.Info("  Fenced %s %s/%s off all nodes (was scheduled on %d)",
+		ctrl.Kind, ctrl.Namespace, ctrl.Name, ds.Status.DesiredNumberScheduled)
+	return nil
+}
+
+// restoreDaemonSet puts back the pod template saved when the DaemonSet was fenced.
+func (s Scaler) restoreDaemonSet(ctx context.Context, ctrl common.ControllerRef) error {
+	daemonSets := s.clientset.AppsV1().DaemonSets(ctrl.Namespace)
+	ds, err := daemonSets.Get(ctx, ctrl.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get %s %s/%s: %w", ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
+	}
+
+	template, ok := ds.Annotations[common.AnnotationOriginalTemplate]
+	if !ok {
+		s.log.Info("%s %s/%s was not fenced by unmount, skipping", ctrl.Kind, ctrl.Namespace, ctrl.Name)
+		return nil
+	}
+	if !json.Valid([]byte(template)) {
+		return fmt.Errorf("invalid %s annotation on %s %s/%s",
+			common.AnnotationOriginalTemplate, ctrl.Kind, ctrl.Namespace, ctrl.Name)
+	}
+
+	// Replace the whole template (rather than merging) so that it's exactly as it was
+	patch, _ := json.Marshal([]map[string]any{
+		{"op": "replace", "path": "/spec/template", "value": json.RawMessage(template)},
+		{"op": "remove", "path": "/metadata/annotations/" + escapeJSONPointer(common.AnnotationOriginalTemplate)},
+	})
+	if _, err := daemonSets.Patch(ctx, ctrl.Name, types.JSONPatchType, patch, s.patchOptions()); err != nil {
+		return fmt.Errorf("failed to restore %s %s/%s: %w", ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
+	}
+
+	s.log.Info("  Restored original template of %s %s/%s", ctrl.Kind, ctrl.Namespace, ctrl.Name)
+	return nil
+}
+
+// escapeJSONPointer escapes a key for use in a JSON patch path, as per RFC 6901.
+func escapeJSONPointer(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,112 @@
+package scaling
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Action describes what ScaleDown does with a controller.
+type Action string
+
+const (
+	ActionScaleDown Action = "ScaleDown"
+	ActionDelete    Action = "Delete"
+	ActionSuspend   Action = "Suspend"
+	ActionWait      Action = "Wait"
+	ActionFence     Action = "Fence"
+	ActionQuiesce   Action = "Quiesce"
+	ActionHalt      Action = "Halt"
+	ActionNone      Action = "None"
+	ActionSkip      Action = "Skip"
+)
+
+// Description describes how ScaleDown will handle a controller. Replica counts are only set for
+// controllers which can be scaled.
+type Description struct {
+	Action          Action
+	CurrentReplicas *int32
+	TargetReplicas  *int32
+	// Autoscalers are paused while the controller is scaled down, as "Kind/name"
+	Autoscalers []string
+}
+
+// Describe returns what ScaleDown would do with the controller, without modifying anything. obj is the
+// controller as returned by GetObject, if it's already been looked up.
+func (s Scaler) Describe(ctx context.Context, ctrl common.ControllerRef, obj *unstructured.Unstructured) (Description, error) {
+	desc, err := s.describe(ctx, ctrl, obj)
+	if err != nil || desc.Action != ActionScaleDown || !s.pauseAutoscalers {
+		return desc, err
+	}
+
+	desc.Autoscalers, err = s.FindAutoscalers(ctx, ctrl)
+	return desc, err
+}
+
+func (s Scaler) describe(ctx context.Context, ctrl common.ControllerRef, obj *unstructured.Unstructured) (Description, error) {
+	switch ctrl.BuiltinKind() {
+	case common.KindDeployment:
+		return describeScalable(ctx, s.clientset.AppsV1().Deployments(ctrl.Namespace), ctrl)
+	case common.KindStatefulSet:
+		return describeScalable(ctx, s.clientset.AppsV1().StatefulSets(ctrl.Namespace), ctrl)
+	case common.KindReplicaSet:
+		return describeScalable(ctx, s.clientset.AppsV1().ReplicaSets(ctrl.Namespace), ctrl)
+	case common.KindPod:
+		return Description{Action: ActionDelete}, nil
+	case common.KindCronJob:
+		return Description{Action: ActionSuspend}, nil
+	case common.KindJob:
+		return Description{Action: jobActions[s.jobAction]}, nil
+	case common.KindDaemonSet:
+		if s.fenceDaemonSets {
+			return Description{Action: ActionFence}, nil
+		}
+		return Description{Action: ActionSkip}, nil
+	default:
+		if IsVirtualMachine(ctrl) {
+			return Description{Action: ActionHalt}, nil
+		}
+		if _, ok := operatorHandlerFor(ctrl); ok {
+			return Description{Action: ActionQuiesce}, nil
+		}
+		if rule, _, err := s.ruleFor(ctx, ctrl, obj); err != nil || rule != nil {
+			return Description{Action: ActionQuiesce}, err
+		}
+		scalable, ok, err := s.genericScalable(ctrl)
+		if err != nil || !ok {
+			return Description{Action: ActionSkip}, err
+		}
+		return describeScalable(ctx, scalable, ctrl)
+	}
+}
+
+var jobActions = map[JobAction]Action{
+	JobActionSuspend: ActionSuspend,
+	JobActionWait:    ActionWait,
+	JobActionDelete:  ActionDelete,
+}
+
+type scaleGetter interface {
+	GetScale(ctx context.Context, name string, options metav1.GetOptions) (*autoscalingv1.Scale, error)
+}
+
+func describeScalable(ctx context.Context, getter scaleGetter, ctrl common.ControllerRef) (Description, error) {
+	scale, err := getter.GetScale(ctx, ctrl.Name, metav1.GetOptions{})
+	if err != nil {
+		return Description{}, fmt.Errorf("failed to get scale for %s %s/%s: %w", ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
+	}
+
+	desc := Description{
+		Action:          ActionScaleDown,
+		CurrentReplicas: common.Int32P(scale.Spec.Replicas),
+		TargetReplicas:  common.Int32P(0),
+	}
+	if scale.Spec.Replicas == 0 {
+		desc.Action = ActionNone
+	}
+	return desc, nil
+}
@@ -0,0 +1,37 @@
+package scaling
+
+import metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+// DryRunMode is whether changes are actually made.
+type DryRunMode string
+
+const (
+	// DryRunNone makes changes
+	DryRunNone DryRunMode = "none"
+	// DryRunClient skips every change, without sending anything to the API server
+	DryRunClient DryRunMode = "client"
+	// DryRunServer sends every change as a server-side dry run, so that it's validated by RBAC, admission
+	// webhooks and conflict checks, but not persisted
+	DryRunServer DryRunMode = "server"
+)
+
+var DryRunModes = []DryRunMode{DryRunNone, DryRunClient, DryRunServer}
+
+func (s Scaler) dryRunOption() []string {
+	if s.dryRun == DryRunServer {
+		return []string{metav1.DryRunAll}
+	}
+	return nil
+}
+
+func (s Scaler) patchOptions() metav1.PatchOptions {
+	return metav1.PatchOptions{DryRun: s.dryRunOption()}
+}
+
+func (s Scaler) updateOptions() metav1.UpdateOptions {
+	return metav1.UpdateOptions{DryRun: s.dryRunOption()}
+}
+
+func (s Scaler) deleteOptions() metav1.DeleteOptions {
+	return metav1.DeleteOptions{DryRun: s.dryRunOption()}
+}
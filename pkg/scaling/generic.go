@@ -0,0 +1,121 @@
+package scaling
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/scale"
+)
+
+// polymorphicScalable adapts the dynamic and polymorphic scale clients to the scalable interface, so that
+// any resource with a scale subresource can be scaled just like the built-in controllers.
+type polymorphicScalable struct {
+	resource dynamic.ResourceInterface
+	scales   scale.ScaleInterface
+	gr       schema.GroupResource
+}
+
+func (p polymorphicScalable) Get(ctx context.Context, name string, options metav1.GetOptions) (*unstructured.Unstructured, error) {
+	return p.resource.Get(ctx, name, options)
+}
+
+func (p polymorphicScalable) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	return p.resource.Patch(ctx, name, pt, data, opts, subresources...)
+}
+
+func (p polymorphicScalable) GetScale(ctx context.Context, name string, options metav1.GetOptions) (*autoscalingv1.Scale, error) {
+	return p.scales.Get(ctx, p.gr, name, options)
+}
+
+func (p polymorphicScalable) UpdateScale(ctx context.Context, name string, scale *autoscalingv1.Scale, opts metav1.UpdateOptions) (*autoscalingv1.Scale, error) {
+	return p.scales.Update(ctx, p.gr, scale, opts)
+}
+
+// genericScalable returns a scalable client for the controller if its resource has a scale subresource,
+// or false if it can't be scaled.
+func (s Scaler) genericScalable(ctrl common.ControllerRef) (polymorphicScalable, bool, error) {
+	gvk := ctrl.GroupVersionKind()
+	if gvk.Version == "" {
+		return polymorphicScalable{}, false, nil
+	}
+
+	mapping, err := s.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return polymorphicScalable{}, false, fmt.Errorf("failed to find resource for %s: %w", gvk, err)
+	}
+
+	resources, err := s.discovery.ServerResourcesForGroupVersion(mapping.Resource.GroupVersion().String())
+	if err != nil {
+		return polymorphicScalable{}, false, fmt.Errorf("failed to discover resources for %s: %w", mapping.Resource.GroupVersion(), err)
+	}
+	hasScale := slices.ContainsFunc(resources.APIResources, func(r metav1.APIResource) bool {
+		return r.Name == mapping.Resource.Resource+"/scale"
+	})
+	if !hasScale {
+		return polymorphicScalable{}, false, nil
+	}
+
+	return polymorphicScalable{
+		resource: s.dynamic.Resource(mapping.Resource).Namespace(ctrl.Namespace),
+		scales:   s.scales.Scales(ctrl.Namespace),
+		gr:       mapping.Resource.GroupResource(),
+	}, true, nil
+}
+
+// scaleGenericToZero scales down any resource with a scale subresource.
+func (s Scaler) scaleGenericToZero(ctx context.Context, ctrl common.ControllerRef) error {
+	scalable, ok, err := s.genericScalable(ctrl)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		// Custom resources without a scale subresource usually belong to an operator, which would revert any
+		// change made to the workloads it manages
+		s.log.Warn("Unsupported controller type %s for %s/%s (no scale subresource, supported operator or handler rule), skipping",
+			ctrl.Kind, ctrl.Namespace, ctrl.Name)
+		return nil
+	}
+	return scaleControllerToZero[*unstructured.Unstructured](ctx, s, scalable, ctrl)
+}
+
+// restoreGeneric restores any resource with a scale subresource.
+func (s Scaler) restoreGeneric(ctx context.Context, ctrl common.ControllerRef) error {
+	scalable, ok, err := s.genericScalable(ctrl)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		s.log.Warn("Cannot restore %s %s/%s, skipping", ctrl.Kind, ctrl.Namespace, ctrl.Name)
+		return nil
+	}
+	return restoreController[*unstructured.Unstructured](ctx, s, scalable, ctrl)
+}
+
+// GetObject gets any controller, as an unstructured object, so that it can be looked up once and then checked
+// for protection, handler rules and GitOps owners.
+func (s Scaler) GetObject(ctx context.Context, ctrl common.ControllerRef) (*unstructured.Unstructured, error) {
+	gvk := ctrl.GroupVersionKind()
+	mapping, err := s.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find resource for %s: %w", gvk, err)
+	}
+
+	var resource dynamic.ResourceInterface = s.dynamic.Resource(mapping.Resource)
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		resource = s.dynamic.Resource(mapping.Resource).Namespace(ctrl.Namespace)
+	}
+	obj, err := resource.Get(ctx, ctrl.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s %s/%s: %w", ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
+	}
+	return obj, nil
+}
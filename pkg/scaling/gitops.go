@@ -0,0 +1,232 @@
+package scaling
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	kindApplication   = "Application"
+	kindKustomization = "Kustomization"
+	kindHelmRelease   = "HelmRelease"
+
+	argoCDTrackingIDAnnotation = "argocd.argoproj.io/tracking-id"
+	argoCDInstanceLabel        = "argocd.argoproj.io/instance"
+	// instanceLabel is Argo CD's default tracking label, but it's also set by Helm charts so it's only
+	// trusted if a matching Application exists
+	instanceLabel = "app.kubernetes.io/instance"
+
+	fluxKustomizationNameLabel      = "kustomize.toolkit.fluxcd.io/name"
+	fluxKustomizationNamespaceLabel = "kustomize.toolkit.fluxcd.io/namespace"
+	fluxHelmReleaseNameLabel        = "helm.toolkit.fluxcd.io/name"
+	fluxHelmReleaseNamespaceLabel   = "helm.toolkit.fluxcd.io/namespace"
+)
+
+var gitOpsGroupKinds = map[string]schema.GroupKind{
+	kindApplication:   {Group: "argoproj.io", Kind: kindApplication},
+	kindKustomization: {Group: "kustomize.toolkit.fluxcd.io", Kind: kindKustomization},
+	kindHelmRelease:   {Group: "helm.toolkit.fluxcd.io", Kind: kindHelmRelease},
+}
+
+// GitOpsOwner is an Argo CD Application, Flux Kustomization or Flux HelmRelease which reconciles a controller,
+// and would undo any changes made to it.
+type GitOpsOwner struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+func (o GitOpsOwner) String() string {
+	return fmt.Sprintf("%s %s/%s", o.Kind, o.Namespace, o.Name)
+}
+
+// FindGitOpsOwners finds the GitOps objects reconciling each controller, as returned by GetObject, from Argo
+// CD's tracking annotation and labels, and Flux's labels. The owners are returned in the same order as the
+// objects.
+func (s Scaler) FindGitOpsOwners(ctx context.Context, objects []*unstructured.Unstructured) [][]GitOpsOwner {
+	// Applications named by the instance label are looked up once, however many controllers share it
+	applications := make(map[GitOpsOwner]bool)
+	owners := make([][]GitOpsOwner, len(objects))
+	for i, obj := range objects {
+		owners[i] = s.gitOpsOwnersOf(ctx, obj, applications)
+	}
+	return owners
+}
+
+func (s Scaler) gitOpsOwnersOf(ctx context.Context, obj metav1.Object,
+	applications map[GitOpsOwner]bool) []GitOpsOwner {
+	labels := obj.GetLabels()
+
+	var owners []GitOpsOwner
+	if trackingID, ok := obj.GetAnnotations()[argoCDTrackingIDAnnotation]; ok {
+		// The tracking ID is "<app>:<group>/<kind>:<namespace>/<name>", and the app may be "<namespace>_<name>"
+		app, _, _ := strings.Cut(trackingID, ":")
+		owners = append(owners, s.argoCDApplication(app))
+	} else if app, ok := labels[argoCDInstanceLabel]; ok {
+		owners = append(owners, s.argoCDApplication(app))
+	} else if app, ok := labels[instanceLabel]; ok {
+		owner := s.argoCDApplication(app)
+		exists, ok := applications[owner]
+		if !ok {
+			_, err := s.getGitOpsOwner(ctx, owner)
+			exists = err == nil
+			applications[owner] = exists
+		}
+		if exists {
+			owners = append(owners, owner)
+		}
+	}
+
+	if name, ok := labels[fluxKustomizationNameLabel]; ok {
+		ns := fluxNamespace(obj, fluxKustomizationNamespaceLabel)
+		owners = append(owners, GitOpsOwner{Kind: kindKustomization, Namespace: ns, Name: name})
+	}
+	if name, ok := labels[fluxHelmReleaseNameLabel]; ok {
+		ns := fluxNamespace(obj, fluxHelmReleaseNamespaceLabel)
+		owners = append(owners, GitOpsOwner{Kind: kindHelmRelease, Namespace: ns, Name: name})
+	}
+
+	return owners
+}
+
+// fluxNamespace returns the namespace of the Flux object reconciling obj, from the given label, or obj's own
+// namespace if the label is missing or empty.
+func fluxNamespace(obj metav1.Object, label string) string {
+	if ns := obj.GetLabels()[label]; ns != "" {
+		return ns
+	}
+	return obj.GetNamespace()
+}
+
+func (s Scaler) argoCDApplication(app string) GitOpsOwner {
+	if ns, name, found := strings.Cut(app, "_"); found {
+		return GitOpsOwner{Kind: kindApplication, Namespace: ns, Name: name}
+	}
+	return GitOpsOwner{Kind: kindApplication, Namespace: s.argoCDNamespace, Name: app}
+}
+
+// SuspendGitOps stops the owner from reconciling, so that it doesn't revert the unmount. Argo CD
+// Applications have automated sync disabled, and Flux objects are suspended.
+func (s Scaler) SuspendGitOps(ctx context.Context, owner GitOpsOwner) error {
+	if s.dryRun == DryRunClient {
+		s.log.Info("  (dry-run, not suspending %v)", owner)
+		return nil
+	}
+
+	obj, err := s.getGitOpsOwner(ctx, owner)
+	if err != nil {
+		return err
+	}
+
+	var patch []byte
+	if owner.Kind == kindApplication {
+		automated, found, _ := unstructured.NestedMap(obj.Object, "spec", "syncPolicy", "automated")
+		if !found {
+			s.log.Info("%v doesn't sync automatically", owner)
+			return nil
+		}
+		original, _ := json.Marshal(automated)
+		patch = mergePatch(
+			map[string]any{common.AnnotationOriginalAutomatedSync: string(original)},
+			map[string]any{"syncPolicy": map[string]any{"automated": nil}},
+		)
+	} else {
+		if suspended, _, _ := unstructured.NestedBool(obj.Object, "spec", "suspend"); suspended {
+			s.log.Info("%v is already suspended", owner)
+			return nil
+		}
+		patch = mergePatch(
+			map[string]any{common.AnnotationOriginalSuspend: strconv.FormatBool(false)},
+			map[string]any{"suspend": true},
+		)
+	}
+
+	if err := s.patchGitOpsOwner(ctx, owner, patch); err != nil {
+		return fmt.Errorf("failed to suspend %v: %w", owner, err)
+	}
+	s.log.Info("  Suspended %v", owner)
+	return nil
+}
+
+// ResumeGitOps undoes SuspendGitOps. Owners which weren't suspended by unmount are left alone.
+func (s Scaler) ResumeGitOps(ctx context.Context, owner GitOpsOwner) error {
+	if s.dryRun == DryRunClient {
+		s.log.Info("  (dry-run, not resuming %v)", owner)
+		return nil
+	}
+
+	obj, err := s.getGitOpsOwner(ctx, owner)
+	if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var patch []byte
+	annotations := obj.GetAnnotations()
+	if original, ok := annotations[common.AnnotationOriginalAutomatedSync]; ok {
+		var automated map[string]any
+		if err := json.Unmarshal([]byte(original), &automated); err != nil {
+			return fmt.Errorf("invalid %s annotation on %v: %w", common.AnnotationOriginalAutomatedSync, owner, err)
+		}
+		patch = mergePatch(
+			map[string]any{common.AnnotationOriginalAutomatedSync: nil},
+			map[string]any{"syncPolicy": map[string]any{"automated": automated}},
+		)
+	} else if original, ok := annotations[common.AnnotationOriginalSuspend]; ok {
+		suspend, err := strconv.ParseBool(original)
+		if err != nil {
+			return fmt.Errorf("invalid %s annotation on %v: %w", common.AnnotationOriginalSuspend, owner, err)
+		}
+		patch = mergePatch(
+			map[string]any{common.AnnotationOriginalSuspend: nil},
+			map[string]any{"suspend": suspend},
+		)
+	} else {
+		return nil
+	}
+
+	if err := s.patchGitOpsOwner(ctx, owner, patch); err != nil {
+		return fmt.Errorf("failed to resume %v: %w", owner, err)
+	}
+	s.log.Info("  Resumed %v", owner)
+	return nil
+}
+
+func (s Scaler) gitOpsResource(owner GitOpsOwner) (dynamic.ResourceInterface, error) {
+	mapping, err := s.mapper.RESTMapping(gitOpsGroupKinds[owner.Kind])
+	if err != nil {
+		return nil, fmt.Errorf("failed to find resource for %s: %w", owner.Kind, err)
+	}
+	return s.dynamic.Resource(mapping.Resource).Namespace(owner.Namespace), nil
+}
+
+func (s Scaler) getGitOpsOwner(ctx context.Context, owner GitOpsOwner) (*unstructured.Unstructured, error) {
+	resource, err := s.gitOpsResource(owner)
+	if err != nil {
+		return nil, err
+	}
+	return resource.Get(ctx, owner.Name, metav1.GetOptions{})
+}
+
+func (s Scaler) patchGitOpsOwner(ctx context.Context, owner GitOpsOwner, patch []byte) error {
+	resource, err := s.gitOpsResource(owner)
+	if err != nil {
+		return err
+	}
+	_, err = resource.Patch(ctx, owner.Name, types.MergePatchType, patch, s.patchOptions())
+	return err
+}
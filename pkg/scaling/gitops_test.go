@@ -0,0 +1,114 @@
+package scaling
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/kube/kubetest"
+	"github.com/dancavallaro/kubectl-unmount/pkg/logger"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func newApplication(namespace, name string) *unstructured.Unstructured {
+	obj := newCustomResource("argoproj.io/v1alpha1", kindApplication, map[string]any{})
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	return obj
+}
+
+func newManagedDeployment(labels, annotations map[string]string) *unstructured.Unstructured {
+	obj := newCustomResource("apps/v1", "Deployment", map[string]any{})
+	obj.SetName("test-deployment")
+	obj.SetLabels(labels)
+	obj.SetAnnotations(annotations)
+	return obj
+}
+
+func TestFindGitOpsOwners(t *testing.T) {
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		annotations map[string]string
+		want        []GitOpsOwner
+	}{
+		{
+			name: "unmanaged",
+		},
+		{
+			name:        "Argo CD tracking ID",
+			annotations: map[string]string{argoCDTrackingIDAnnotation: "my-app:apps/Deployment:test-ns/test-deployment"},
+			want:        []GitOpsOwner{{Kind: kindApplication, Namespace: "argocd", Name: "my-app"}},
+		},
+		{
+			name:        "Argo CD tracking ID of an Application in another namespace",
+			annotations: map[string]string{argoCDTrackingIDAnnotation: "apps_my-app:apps/Deployment:test-ns/test-deployment"},
+			want:        []GitOpsOwner{{Kind: kindApplication, Namespace: "apps", Name: "my-app"}},
+		},
+		{
+			name:   "Argo CD instance label",
+			labels: map[string]string{argoCDInstanceLabel: "my-app"},
+			want:   []GitOpsOwner{{Kind: kindApplication, Namespace: "argocd", Name: "my-app"}},
+		},
+		{
+			name:   "instance label of an existing Application",
+			labels: map[string]string{instanceLabel: "my-app"},
+			want:   []GitOpsOwner{{Kind: kindApplication, Namespace: "argocd", Name: "my-app"}},
+		},
+		{
+			name:   "instance label set by a Helm chart",
+			labels: map[string]string{instanceLabel: "my-release"},
+		},
+		{
+			name:   "Flux Kustomization",
+			labels: map[string]string{fluxKustomizationNameLabel: "apps", fluxKustomizationNamespaceLabel: "flux-system"},
+			want:   []GitOpsOwner{{Kind: kindKustomization, Namespace: "flux-system", Name: "apps"}},
+		},
+		{
+			name:   "Flux HelmRelease",
+			labels: map[string]string{fluxHelmReleaseNameLabel: "db", fluxHelmReleaseNamespaceLabel: "flux-system"},
+			want:   []GitOpsOwner{{Kind: kindHelmRelease, Namespace: "flux-system", Name: "db"}},
+		},
+		{
+			name:   "Flux namespace label missing",
+			labels: map[string]string{fluxKustomizationNameLabel: "apps"},
+			want:   []GitOpsOwner{{Kind: kindKustomization, Namespace: testNamespace, Name: "apps"}},
+		},
+		{
+			name:   "Flux namespace label empty",
+			labels: map[string]string{fluxHelmReleaseNameLabel: "db", fluxHelmReleaseNamespaceLabel: ""},
+			want:   []GitOpsOwner{{Kind: kindHelmRelease, Namespace: testNamespace, Name: "db"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients := kubetest.NewClients(newApplication("argocd", "my-app"))
+			scaler := New(clients.Clients, logger.NewLogger(io.Discard), Options{})
+
+			owners := scaler.FindGitOpsOwners(context.Background(),
+				[]*unstructured.Unstructured{newManagedDeployment(tt.labels, tt.annotations)})
+			require.Equal(t, [][]GitOpsOwner{tt.want}, owners)
+		})
+	}
+}
+
+func TestFindGitOpsOwnersLooksUpApplicationsOnce(t *testing.T) {
+	clients := kubetest.NewClients(newApplication("argocd", "my-app"))
+	scaler := New(clients.Clients, logger.NewLogger(io.Discard), Options{})
+
+	app := newManagedDeployment(map[string]string{instanceLabel: "my-app"}, nil)
+	release := newManagedDeployment(map[string]string{instanceLabel: "my-release"}, nil)
+	owners := scaler.FindGitOpsOwners(context.Background(), []*unstructured.Unstructured{app, release, app, release})
+	owner := GitOpsOwner{Kind: kindApplication, Namespace: "argocd", Name: "my-app"}
+	require.Equal(t, [][]GitOpsOwner{{owner}, nil, {owner}, nil}, owners)
+
+	gets := map[string]int{}
+	for _, action := range clients.FakeDynamic.Actions() {
+		if action.GetVerb() == "get" && action.GetResource().Resource == "applications" {
+			gets[action.(interface{ GetName() string }).GetName()]++
+		}
+	}
+	require.Equal(t, map[string]int{"my-app": 1, "my-release": 1}, gets)
+}
@@ -0,0 +1,185 @@
+package scaling
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/utils/ptr"
+)
+
+// jobPollInterval is how often a Job is checked for completion with JobActionWait.
+const jobPollInterval = time.Second
+
+// JobAction is what to do with a Job whose pods mount the volumes being unmounted.
+type JobAction string
+
+const (
+	// JobActionSuspend suspends the Job, which terminates its pods. It's resumed on restore.
+	JobActionSuspend JobAction = "suspend"
+	// JobActionWait leaves the Job alone, and waits for it to complete.
+	JobActionWait JobAction = "wait"
+	// JobActionDelete deletes the Job along with its pods. It can't be restored.
+	JobActionDelete JobAction = "delete"
+)
+
+// JobActions are all supported job actions, in the order they're documented.
+var JobActions = []JobAction{JobActionSuspend, JobActionWait, JobActionDelete}
+
+// suspendCronJob suspends a CronJob so it doesn't start any new Jobs, then handles its active Jobs.
+func (s Scaler) suspendCronJob(ctx context.Context, ctrl common.ControllerRef) error {
+	cronJobs := s.clientset.BatchV1().CronJobs(ctrl.Namespace)
+	cronJob, err := cronJobs.Get(ctx, ctrl.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get %s %s/%s: %w", ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
+	}
+
+	if ptr.Deref(cronJob.Spec.Suspend, false) {
+		s.log.Info("%s %s/%s is already suspended", ctrl.Kind, ctrl.Namespace, ctrl.Name)
+	} else {
+		if err := suspend(ctx, s, cronJobs, ctrl); err != nil {
+			return err
+		}
+		s.log.Info("  Suspended %s %s/%s", ctrl.Kind, ctrl.Namespace, ctrl.Name)
+	}
+
+	for _, active := range cronJob.Status.Active {
+		job := common.ControllerRef{APIVersion: "batch/v1", Kind: common.KindJob, Namespace: ctrl.Namespace, Name: active.Name}
+		if err := s.handleJob(ctx, job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleJob applies the configured JobAction to a Job.
+func (s Scaler) handleJob(ctx context.Context, ctrl common.ControllerRef) error {
+	jobs := s.clientset.BatchV1().Jobs(ctrl.Namespace)
+
+	switch s.jobAction {
+	case JobActionWait:
+		return s.waitForJob(ctx, ctrl)
+	case JobActionDelete:
+		deleteOptions := s.deleteOptions()
+		deleteOptions.PropagationPolicy = ptr.To(metav1.DeletePropagationBackground)
+		err := jobs.Delete(ctx, ctrl.Name, deleteOptions)
+		if err != nil {
+			return fmt.Errorf("failed to delete %s %s/%s: %w", ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
+		}
+		s.log.Info("  Deleted %s %s/%s (not restorable)", ctrl.Kind, ctrl.Namespace, ctrl.Name)
+		return nil
+	default:
+		job, err := jobs.Get(ctx, ctrl.Name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get %s %s/%s: %w", ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
+		}
+		if ptr.Deref(job.Spec.Suspend, false) {
+			s.log.Info("%s %s/%s is already suspended", ctrl.Kind, ctrl.Namespace, ctrl.Name)
+			return nil
+		}
+		if err := suspend(ctx, s, jobs, ctrl); err != nil {
+			return err
+		}
+		s.log.Info("  Suspended %s %s/%s", ctrl.Kind, ctrl.Namespace, ctrl.Name)
+		return nil
+	}
+}
+
+// waitForJob waits until a Job has completed or failed, which is bounded by --timeout like waiting for pods.
+// A Job which no longer exists is done.
+func (s Scaler) waitForJob(ctx context.Context, ctrl common.ControllerRef) error {
+	if s.dryRun == DryRunServer {
+		s.log.Info("  (server dry-run, not waiting for %s %s/%s to complete)", ctrl.Kind, ctrl.Namespace, ctrl.Name)
+		return nil
+	}
+	s.log.Info("  Waiting for %s %s/%s to complete...", ctrl.Kind, ctrl.Namespace, ctrl.Name)
+
+	jobs := s.clientset.BatchV1().Jobs(ctrl.Namespace)
+	var finished batchv1.JobConditionType
+	err := wait.PollUntilContextCancel(ctx, jobPollInterval, true, func(ctx context.Context) (bool, error) {
+		job, err := jobs.Get(ctx, ctrl.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		for _, condition := range job.Status.Conditions {
+			if (condition.Type == batchv1.JobComplete || condition.Type == batchv1.JobFailed) &&
+				condition.Status == corev1.ConditionTrue {
+				finished = condition.Type
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed waiting for %s %s/%s to complete: %w", ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
+	}
+
+	switch finished {
+	case batchv1.JobComplete:
+		s.log.Info("  %s %s/%s completed", ctrl.Kind, ctrl.Namespace, ctrl.Name)
+	case batchv1.JobFailed:
+		s.log.Warn("%s %s/%s failed", ctrl.Kind, ctrl.Namespace, ctrl.Name)
+	default:
+		s.log.Info("  %s %s/%s no longer exists", ctrl.Kind, ctrl.Namespace, ctrl.Name)
+	}
+	return nil
+}
+
+type suspendable[T metav1.Object] interface {
+	Get(ctx context.Context, name string, options metav1.GetOptions) (T, error)
+	Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (T, error)
+}
+
+// suspend sets spec.suspend, recording that it was previously unset so that it can be restored.
+func suspend[T metav1.Object](ctx context.Context, s Scaler, client suspendable[T], ctrl common.ControllerRef) error {
+	patch := mergePatch(
+		map[string]any{common.AnnotationOriginalSuspend: strconv.FormatBool(false)},
+		map[string]any{"suspend": true},
+	)
+	if _, err := client.Patch(ctx, ctrl.Name, types.MergePatchType, patch, s.patchOptions()); err != nil {
+		return fmt.Errorf("failed to suspend %s %s/%s: %w", ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
+	}
+	return nil
+}
+
+// restoreSuspended resets spec.suspend of a CronJob or Job to the value it had before it was suspended.
+func restoreSuspended[T metav1.Object](ctx context.Context, s Scaler, client suspendable[T], ctrl common.ControllerRef) error {
+	log := s.log
+	obj, err := client.Get(ctx, ctrl.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get %s %s/%s: %w", ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
+	}
+
+	value, ok := obj.GetAnnotations()[common.AnnotationOriginalSuspend]
+	if !ok {
+		log.Info("%s %s/%s was not suspended by unmount, skipping", ctrl.Kind, ctrl.Namespace, ctrl.Name)
+		return nil
+	}
+	originalSuspend, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s annotation %q on %s %s/%s: %w",
+			common.AnnotationOriginalSuspend, value, ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
+	}
+
+	patch := mergePatch(
+		map[string]any{common.AnnotationOriginalSuspend: nil},
+		map[string]any{"suspend": originalSuspend},
+	)
+	if _, err := client.Patch(ctx, ctrl.Name, types.MergePatchType, patch, s.patchOptions()); err != nil {
+		return fmt.Errorf("failed to resume %s %s/%s: %w", ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
+	}
+
+	log.Info("  Resumed %s %s/%s", ctrl.Kind, ctrl.Namespace, ctrl.Name)
+	return nil
+}
@@ -0,0 +1,92 @@
+package scaling
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	"github.com/dancavallaro/kubectl-unmount/pkg/kube/kubetest"
+	"github.com/dancavallaro/kubectl-unmount/pkg/logger"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var testJob = common.ControllerRef{APIVersion: "batch/v1", Kind: common.KindJob, Namespace: testNamespace, Name: "test-job"}
+
+func newJob(conditions ...batchv1.JobCondition) *batchv1.Job {
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: testJob.Name, Namespace: testNamespace},
+		Status:     batchv1.JobStatus{Conditions: conditions},
+	}
+}
+
+func TestWaitForJob(t *testing.T) {
+	complete := batchv1.JobCondition{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}
+	failed := batchv1.JobCondition{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}
+
+	tests := []struct {
+		name string
+		objs []runtime.Object
+		// completeAfter is how many times the Job is still running when it's looked up
+		completeAfter int
+		wantLog       string
+	}{
+		{
+			name:    "completed",
+			objs:    []runtime.Object{newJob(complete)},
+			wantLog: "Job test-ns/test-job completed",
+		},
+		{
+			name:    "failed",
+			objs:    []runtime.Object{newJob(failed)},
+			wantLog: "Job test-ns/test-job failed",
+		},
+		{
+			name:    "deleted",
+			wantLog: "Job test-ns/test-job no longer exists",
+		},
+		{
+			name:          "completes while waiting",
+			objs:          []runtime.Object{newJob()},
+			completeAfter: 1,
+			wantLog:       "Job test-ns/test-job completed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients := kubetest.NewClients(tt.objs...)
+			gets := 0
+			clients.FakeClientset.PrependReactor("get", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				gets++
+				if tt.completeAfter > 0 && gets > tt.completeAfter {
+					return true, newJob(complete), nil
+				}
+				return false, nil, nil
+			})
+			var logs bytes.Buffer
+			scaler := New(clients.Clients, logger.NewLogger(&logs), Options{JobAction: JobActionWait})
+
+			require.NoError(t, scaler.ScaleDown(context.Background(), testJob))
+			require.Contains(t, logs.String(), tt.wantLog)
+			require.Equal(t, tt.completeAfter+1, gets)
+		})
+	}
+}
+
+func TestWaitForJobTimeout(t *testing.T) {
+	clients := kubetest.NewClients(newJob(batchv1.JobCondition{Type: batchv1.JobComplete, Status: corev1.ConditionFalse}))
+	scaler := New(clients.Clients, logger.NewLogger(&bytes.Buffer{}), Options{JobAction: JobActionWait})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := scaler.ScaleDown(ctx, testJob)
+	require.ErrorContains(t, err, "failed waiting for Job test-ns/test-job to complete")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -0,0 +1,199 @@
+package scaling
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	"github.com/dancavallaro/kubectl-unmount/pkg/kube"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	kindVirtualMachine         = "VirtualMachine"
+	kindVirtualMachineInstance = "VirtualMachineInstance"
+	runStrategyHalted          = "Halted"
+)
+
+var (
+	virtualMachineKind         = schema.GroupKind{Group: "kubevirt.io", Kind: kindVirtualMachine}
+	virtualMachineInstanceKind = schema.GroupKind{Group: "kubevirt.io", Kind: kindVirtualMachineInstance}
+)
+
+// IsVirtualMachine returns true if the controller is a KubeVirt VirtualMachine, or a VirtualMachineInstance
+// which is stopped through its VirtualMachine.
+func IsVirtualMachine(ctrl common.ControllerRef) bool {
+	gk := ctrl.GroupVersionKind().GroupKind()
+	return gk == virtualMachineKind || gk == virtualMachineInstanceKind
+}
+
+// virtualMachineFor returns the VirtualMachine of a VirtualMachineInstance. KubeVirt always names a
+// VirtualMachine's instance after it, so this works even once the instance has been deleted.
+func virtualMachineFor(ctrl common.ControllerRef) common.ControllerRef {
+	ctrl.Kind = kindVirtualMachine
+	return ctrl
+}
+
+// haltVirtualMachine stops a VirtualMachine by setting spec.runStrategy to Halted, or spec.running to false
+// on older versions of KubeVirt, recording the original value so that it can be restored. KubeVirt then
+// deletes the VirtualMachineInstance and its virt-launcher pod, which is waited for like any other pod.
+func (s Scaler) haltVirtualMachine(ctx context.Context, ctrl common.ControllerRef) error {
+	vm := virtualMachineFor(ctrl)
+	resource, err := s.resourceFor(vm)
+	if err != nil {
+		return err
+	}
+	obj, err := resource.Get(ctx, vm.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) && ctrl.Kind == kindVirtualMachineInstance {
+		s.log.Warn("VirtualMachineInstance %s/%s has no VirtualMachine, so it can't be stopped and restored, skipping",
+			ctrl.Namespace, ctrl.Name)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get %s %s/%s: %w", vm.Kind, vm.Namespace, vm.Name, err)
+	}
+
+	if _, ok := obj.GetAnnotations()[common.AnnotationOriginalRunStrategy]; ok {
+		s.log.Info("%s %s/%s is already halted", vm.Kind, vm.Namespace, vm.Name)
+		return nil
+	}
+
+	// The spec fields to patch, and their original values. An unset runStrategy is recorded as null, so
+	// it's removed again on restore.
+	var spec, original map[string]any
+	if running, found, _ := unstructured.NestedBool(obj.Object, "spec", "running"); found {
+		if !running {
+			s.log.Info("%s %s/%s is already stopped", vm.Kind, vm.Namespace, vm.Name)
+			return nil
+		}
+		spec = map[string]any{"running": false}
+		original = map[string]any{"running": running}
+	} else {
+		strategy, found, _ := unstructured.NestedString(obj.Object, "spec", "runStrategy")
+		if strategy == runStrategyHalted {
+			s.log.Info("%s %s/%s is already halted", vm.Kind, vm.Namespace, vm.Name)
+			return nil
+		}
+		spec = map[string]any{"runStrategy": runStrategyHalted}
+		original = map[string]any{"runStrategy": nil}
+		if found {
+			original["runStrategy"] = strategy
+		}
+	}
+
+	// Marshalling a map of plain values can't fail
+	data, _ := json.Marshal(original)
+	patch := mergePatch(map[string]any{common.AnnotationOriginalRunStrategy: string(data)}, spec)
+	if _, err := resource.Patch(ctx, vm.Name, types.MergePatchType, patch, s.patchOptions()); err != nil {
+		return fmt.Errorf("failed to halt %s %s/%s: %w", vm.Kind, vm.Namespace, vm.Name, err)
+	}
+	s.log.Info("  Halted %s %s/%s", vm.Kind, vm.Namespace, vm.Name)
+	return nil
+}
+
+// restoreVirtualMachine undoes haltVirtualMachine. VirtualMachines which weren't halted by unmount are left
+// alone.
+func (s Scaler) restoreVirtualMachine(ctx context.Context, ctrl common.ControllerRef) error {
+	vm := virtualMachineFor(ctrl)
+	resource, err := s.resourceFor(vm)
+	if err != nil {
+		return err
+	}
+	obj, err := resource.Get(ctx, vm.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get %s %s/%s: %w", vm.Kind, vm.Namespace, vm.Name, err)
+	}
+
+	value, ok := obj.GetAnnotations()[common.AnnotationOriginalRunStrategy]
+	if !ok {
+		s.log.Info("%s %s/%s was not halted by unmount, skipping", vm.Kind, vm.Namespace, vm.Name)
+		return nil
+	}
+	var original map[string]any
+	if err := json.Unmarshal([]byte(value), &original); err != nil {
+		return fmt.Errorf("invalid %s annotation on %s %s/%s: %w",
+			common.AnnotationOriginalRunStrategy, vm.Kind, vm.Namespace, vm.Name, err)
+	}
+
+	patch := mergePatch(map[string]any{common.AnnotationOriginalRunStrategy: nil}, original)
+	if _, err := resource.Patch(ctx, vm.Name, types.MergePatchType, patch, s.patchOptions()); err != nil {
+		return fmt.Errorf("failed to restore %s %s/%s: %w", vm.Kind, vm.Namespace, vm.Name, err)
+	}
+	s.log.Info("  Restored %s %s/%s", vm.Kind, vm.Namespace, vm.Name)
+	return nil
+}
+
+// FindHaltedVirtualMachines finds the KubeVirt VirtualMachines which were halted by this plugin, and whose
+// volumes or DataVolume templates use any of the given PVCs.
+func (s Scaler) FindHaltedVirtualMachines(ctx context.Context, pvcsPerNs map[string][]string) ([]common.ControllerRef, error) {
+	mapping, err := s.mapper.RESTMapping(virtualMachineKind)
+	if meta.IsNoMatchError(err) {
+		// KubeVirt isn't installed
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find resource for %s: %w", virtualMachineKind, err)
+	}
+
+	var controllers []common.ControllerRef
+	for ns, pvcs := range pvcsPerNs {
+		resource := s.dynamic.Resource(mapping.Resource).Namespace(ns)
+		err := kube.EachListItem(ctx, s.paging, metav1.ListOptions{},
+			func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+				return resource.List(ctx, opts)
+			}, func(obj *unstructured.Unstructured) error {
+				_, halted := obj.GetAnnotations()[common.AnnotationOriginalRunStrategy]
+				if halted && virtualMachineUsesAnyPVC(obj, pvcs) {
+					controllers = append(controllers, common.ControllerRef{
+						APIVersion: mapping.GroupVersionKind.GroupVersion().String(),
+						Kind:       kindVirtualMachine,
+						Namespace:  ns,
+						Name:       obj.GetName(),
+					})
+				}
+				return nil
+			})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", mapping.Resource.GroupResource(), err)
+		}
+	}
+	return controllers, nil
+}
+
+// virtualMachineUsesAnyPVC checks a VirtualMachine's volumes and DataVolume templates for any of the given
+// PVCs. A DataVolume's PVC has the same name as the DataVolume.
+func virtualMachineUsesAnyPVC(obj *unstructured.Unstructured, pvcs []string) bool {
+	volumes, _, _ := unstructured.NestedSlice(obj.Object, "spec", "template", "spec", "volumes")
+	for _, volume := range volumes {
+		volume, ok := volume.(map[string]any)
+		if !ok {
+			continue
+		}
+		claimName, _, _ := unstructured.NestedString(volume, "persistentVolumeClaim", "claimName")
+		dataVolume, _, _ := unstructured.NestedString(volume, "dataVolume", "name")
+		if slices.Contains(pvcs, claimName) || slices.Contains(pvcs, dataVolume) {
+			return true
+		}
+	}
+
+	templates, _, _ := unstructured.NestedSlice(obj.Object, "spec", "dataVolumeTemplates")
+	for _, template := range templates {
+		template, ok := template.(map[string]any)
+		if !ok {
+			continue
+		}
+		name, _, _ := unstructured.NestedString(template, "metadata", "name")
+		if slices.Contains(pvcs, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package scaling
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	"github.com/dancavallaro/kubectl-unmount/pkg/kube/kubetest"
+	"github.com/dancavallaro/kubectl-unmount/pkg/logger"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+var (
+	testVM  = common.ControllerRef{APIVersion: "kubevirt.io/v1", Kind: "VirtualMachine", Namespace: testNamespace, Name: "test-vm"}
+	testVMI = common.ControllerRef{APIVersion: "kubevirt.io/v1", Kind: "VirtualMachineInstance", Namespace: testNamespace, Name: "test-vm"}
+)
+
+func newVirtualMachine(name string, spec map[string]any) *unstructured.Unstructured {
+	obj := newCustomResource(testVM.APIVersion, testVM.Kind, map[string]any{"spec": spec})
+	obj.SetName(name)
+	return obj
+}
+
+func TestHaltVirtualMachine(t *testing.T) {
+	tests := []struct {
+		name       string
+		ctrl       common.ControllerRef
+		spec       map[string]any
+		wantHalted map[string]any
+		wantErr    bool
+	}{
+		{
+			name:       "run strategy",
+			ctrl:       testVM,
+			spec:       map[string]any{"runStrategy": "Always"},
+			wantHalted: map[string]any{"runStrategy": "Halted"},
+		},
+		{
+			name:       "no run strategy",
+			ctrl:       testVM,
+			spec:       map[string]any{},
+			wantHalted: map[string]any{"runStrategy": "Halted"},
+		},
+		{
+			name:       "running on older versions",
+			ctrl:       testVM,
+			spec:       map[string]any{"running": true},
+			wantHalted: map[string]any{"running": false},
+		},
+		{
+			name:       "instance of a VirtualMachine",
+			ctrl:       testVMI,
+			spec:       map[string]any{"runStrategy": "RerunOnFailure"},
+			wantHalted: map[string]any{"runStrategy": "Halted"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients := kubetest.NewClients(newVirtualMachine(testVM.Name, tt.spec))
+			scaler := New(clients.Clients, logger.NewLogger(io.Discard), Options{})
+			original := getCustomResource(t, clients, testVM).Object["spec"]
+
+			desc, err := scaler.Describe(context.Background(), tt.ctrl, nil)
+			require.NoError(t, err)
+			require.Equal(t, ActionHalt, desc.Action)
+
+			require.NoError(t, scaler.ScaleDown(context.Background(), tt.ctrl))
+			obj := getCustomResource(t, clients, testVM)
+			require.Equal(t, tt.wantHalted, obj.Object["spec"])
+			require.Contains(t, obj.GetAnnotations(), common.AnnotationOriginalRunStrategy)
+
+			// Halting again keeps the original run strategy
+			require.NoError(t, scaler.ScaleDown(context.Background(), tt.ctrl))
+
+			require.NoError(t, scaler.Restore(context.Background(), tt.ctrl))
+			obj = getCustomResource(t, clients, testVM)
+			require.Equal(t, original, obj.Object["spec"])
+			require.NotContains(t, obj.GetAnnotations(), common.AnnotationOriginalRunStrategy)
+		})
+	}
+}
+
+func TestHaltStoppedVirtualMachine(t *testing.T) {
+	for _, spec := range []map[string]any{{"runStrategy": "Halted"}, {"running": false}} {
+		clients := kubetest.NewClients(newVirtualMachine(testVM.Name, spec))
+		scaler := New(clients.Clients, logger.NewLogger(io.Discard), Options{})
+
+		require.NoError(t, scaler.ScaleDown(context.Background(), testVM))
+		obj := getCustomResource(t, clients, testVM)
+		require.Equal(t, spec, obj.Object["spec"])
+		// It wasn't halted by unmount, so restore leaves it stopped
+		require.NotContains(t, obj.GetAnnotations(), common.AnnotationOriginalRunStrategy)
+	}
+}
+
+func TestHaltStandaloneVirtualMachineInstance(t *testing.T) {
+	var logBuf bytes.Buffer
+	clients := kubetest.NewClients()
+	scaler := New(clients.Clients, logger.NewLogger(&logBuf), Options{})
+
+	require.NoError(t, scaler.ScaleDown(context.Background(), testVMI))
+	require.Contains(t, logBuf.String(), "VirtualMachineInstance test-ns/test-vm has no VirtualMachine")
+}
+
+func TestFindHaltedVirtualMachines(t *testing.T) {
+	halted := func(vm *unstructured.Unstructured) *unstructured.Unstructured {
+		vm.SetAnnotations(map[string]string{common.AnnotationOriginalRunStrategy: `{"runStrategy":"Always"}`})
+		return vm
+	}
+	volumes := func(volumes ...any) map[string]any {
+		return map[string]any{"template": map[string]any{"spec": map[string]any{"volumes": volumes}}}
+	}
+	objs := []runtime.Object{
+		halted(newVirtualMachine("pvc-vm", volumes(
+			map[string]any{"name": "disk", "persistentVolumeClaim": map[string]any{"claimName": "pvc-disk"}},
+		))),
+		halted(newVirtualMachine("dv-vm", volumes(
+			map[string]any{"name": "disk", "dataVolume": map[string]any{"name": "dv-disk"}},
+		))),
+		halted(newVirtualMachine("template-vm", map[string]any{
+			"dataVolumeTemplates": []any{map[string]any{"metadata": map[string]any{"name": "template-disk"}}},
+		})),
+		halted(newVirtualMachine("other-vm", volumes(
+			map[string]any{"name": "disk", "persistentVolumeClaim": map[string]any{"claimName": "other-disk"}},
+		))),
+		newVirtualMachine("running-vm", volumes(
+			map[string]any{"name": "disk", "persistentVolumeClaim": map[string]any{"claimName": "pvc-disk"}},
+		)),
+	}
+	clients := kubetest.NewClients(objs...)
+	scaler := New(clients.Clients, logger.NewLogger(io.Discard), Options{})
+
+	controllers, err := scaler.FindHaltedVirtualMachines(context.Background(), map[string][]string{
+		testNamespace: {"pvc-disk", "dv-disk", "template-disk"},
+	})
+	require.NoError(t, err)
+	var names []string
+	for _, ctrl := range controllers {
+		require.Equal(t, testVM.APIVersion, ctrl.APIVersion)
+		require.Equal(t, testVM.Kind, ctrl.Kind)
+		names = append(names, ctrl.Name)
+	}
+	require.ElementsMatch(t, []string{"pvc-vm", "dv-vm", "template-vm"}, names)
+}
@@ -0,0 +1,363 @@
+package scaling
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	"github.com/dancavallaro/kubectl-unmount/pkg/kube"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+// operatorHandler quiesces a custom resource through its operator, since the operator would straight away
+// revert its workloads being scaled down directly. The resource is quiesced by setting a single field, e.g.
+// an annotation, whose original value is recorded so that it can be resumed.
+type operatorHandler struct {
+	// operator is the name of the operator, for logs
+	operator string
+	// field is the path of the field which quiesces the resource
+	field []string
+	// quiesced is the value of field which quiesces the resource
+	quiesced any
+	// clusterLabel is set on the resource's PVCs by the operator, to the name of the resource
+	clusterLabel string
+	// scaleStatefulSets also scales down the StatefulSets controlled by the resource, for operators which
+	// only pause reconciliation rather than stopping their workloads
+	scaleStatefulSets bool
+	// unscalable are the kinds of workload which the resource may control instead of StatefulSets, but which
+	// can't be scaled down. Resources controlling any are refused, rather than quiesced and left running.
+	unscalable []schema.GroupKind
+}
+
+// operatorHandlers are the supported operators, keyed on the group and kind of the top-level owner of their
+// workloads.
+var operatorHandlers = map[schema.GroupKind]operatorHandler{
+	{Group: "postgresql.cnpg.io", Kind: "Cluster"}: {
+		operator:     "CloudNativePG",
+		field:        []string{"metadata", "annotations", "cnpg.io/hibernation"},
+		quiesced:     "on",
+		clusterLabel: "cnpg.io/cluster",
+	},
+	{Group: "kafka.strimzi.io", Kind: "Kafka"}: {
+		operator:          "Strimzi",
+		field:             []string{"metadata", "annotations", "strimzi.io/pause-reconciliation"},
+		quiesced:          "true",
+		clusterLabel:      "strimzi.io/cluster",
+		scaleStatefulSets: true,
+		// Strimzi 0.35 and later run brokers from StrimziPodSets, whose pods are listed one by one
+		unscalable: []schema.GroupKind{{Group: "core.strimzi.io", Kind: "StrimziPodSet"}},
+	},
+	{Group: "acid.zalan.do", Kind: "postgresql"}: {
+		operator:     "Zalando Postgres Operator",
+		field:        []string{"spec", "numberOfInstances"},
+		quiesced:     int64(0),
+		clusterLabel: "cluster-name",
+	},
+	{Group: "postgres-operator.crunchydata.com", Kind: "PostgresCluster"}: {
+		operator:     "Crunchy Postgres Operator",
+		field:        []string{"spec", "shutdown"},
+		quiesced:     true,
+		clusterLabel: "postgres-operator.crunchydata.com/cluster",
+	},
+}
+
+func operatorHandlerFor(ctrl common.ControllerRef) (operatorHandler, bool) {
+	handler, ok := operatorHandlers[ctrl.GroupVersionKind().GroupKind()]
+	return handler, ok
+}
+
+// Operator returns the name of the operator which quiesces the controller, if it's a supported operator's
+// custom resource.
+func (s Scaler) Operator(ctrl common.ControllerRef) (string, bool) {
+	handler, ok := operatorHandlerFor(ctrl)
+	return handler.operator, ok
+}
+
+// quiesceOperator quiesces the custom resource through its operator.
+func (s Scaler) quiesceOperator(ctx context.Context, handler operatorHandler, ctrl common.ControllerRef) error {
+	resource, err := s.resourceFor(ctrl)
+	if err != nil {
+		return err
+	}
+	obj, err := resource.Get(ctx, ctrl.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get %s %s/%s: %w", ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
+	}
+
+	for _, gk := range handler.unscalable {
+		controlled, err := s.controls(ctx, obj, gk)
+		if err != nil {
+			return err
+		}
+		if controlled {
+			return fmt.Errorf("cannot quiesce %s %s/%s: its pods are run by %ss, which %s can't scale down",
+				ctrl.Kind, ctrl.Namespace, ctrl.Name, gk.Kind, handler.operator)
+		}
+	}
+
+	current, found, _ := unstructured.NestedFieldCopy(obj.Object, handler.field...)
+	if _, quiesced := obj.GetAnnotations()[common.AnnotationOriginalOperatorState]; quiesced {
+		s.log.Info("%s %s/%s is already quiesced", ctrl.Kind, ctrl.Namespace, ctrl.Name)
+	} else if found && isQuiesced(current, handler.quiesced) {
+		s.log.Info("%s %s/%s is already quiesced by %s", ctrl.Kind, ctrl.Namespace, ctrl.Name, handler.operator)
+	} else {
+		// An unset field is recorded as null, so it's removed again on resume
+		original, err := json.Marshal(current)
+		if err != nil {
+			return fmt.Errorf("failed to save state of %s %s/%s: %w", ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
+		}
+		patch := fieldPatch(handler.field, handler.quiesced, string(original))
+		if _, err := resource.Patch(ctx, ctrl.Name, types.MergePatchType, patch, s.patchOptions()); err != nil {
+			return fmt.Errorf("failed to quiesce %s %s/%s: %w", ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
+		}
+		s.log.Info("  Quiesced %s %s/%s through %s", ctrl.Kind, ctrl.Namespace, ctrl.Name, handler.operator)
+	}
+
+	if !handler.scaleStatefulSets {
+		return nil
+	}
+	// Reconciliation is paused first, so that the operator doesn't scale the StatefulSets straight back up
+	statefulSets, err := s.controlledStatefulSets(ctx, obj)
+	if err != nil {
+		return err
+	}
+	if len(statefulSets) == 0 {
+		s.log.Warn("%s %s/%s controls no StatefulSets, so its pods are left running", ctrl.Kind, ctrl.Namespace, ctrl.Name)
+	}
+	for _, sts := range statefulSets {
+		if err := scaleControllerToZero[*appsv1.StatefulSet](ctx, s, s.clientset.AppsV1().StatefulSets(ctrl.Namespace), sts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resumeOperator undoes quiesceOperator. Resources which weren't quiesced by unmount are left alone.
+func (s Scaler) resumeOperator(ctx context.Context, handler operatorHandler, ctrl common.ControllerRef) error {
+	resource, err := s.resourceFor(ctrl)
+	if err != nil {
+		return err
+	}
+	obj, err := resource.Get(ctx, ctrl.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get %s %s/%s: %w", ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
+	}
+
+	value, ok := obj.GetAnnotations()[common.AnnotationOriginalOperatorState]
+	if !ok {
+		s.log.Info("%s %s/%s was not quiesced by unmount, skipping", ctrl.Kind, ctrl.Namespace, ctrl.Name)
+		return nil
+	}
+	original, err := decodeOriginalState(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s annotation on %s %s/%s: %w",
+			common.AnnotationOriginalOperatorState, ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
+	}
+
+	// StatefulSets are restored while reconciliation is still paused, so that the operator resumes from
+	// where they were
+	if handler.scaleStatefulSets {
+		statefulSets, err := s.controlledStatefulSets(ctx, obj)
+		if err != nil {
+			return err
+		}
+		for _, sts := range statefulSets {
+			if err := restoreController[*appsv1.StatefulSet](ctx, s, s.clientset.AppsV1().StatefulSets(ctrl.Namespace), sts); err != nil {
+				return err
+			}
+		}
+	}
+
+	patch := fieldPatch(handler.field, original, nil)
+	if _, err := resource.Patch(ctx, ctrl.Name, types.MergePatchType, patch, s.patchOptions()); err != nil {
+		return fmt.Errorf("failed to resume %s %s/%s: %w", ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
+	}
+	s.log.Info("  Resumed %s %s/%s through %s", ctrl.Kind, ctrl.Namespace, ctrl.Name, handler.operator)
+	return nil
+}
+
+// FindQuiescedOperators finds the custom resources which were quiesced by this plugin, and whose operator
+// labels any of the given PVCs as belonging to them.
+func (s Scaler) FindQuiescedOperators(ctx context.Context, pvcsPerNs map[string][]string) ([]common.ControllerRef, error) {
+	var controllers []common.ControllerRef
+	for ns, pvcs := range pvcsPerNs {
+		// The operators' resources which own any of the PVCs, keyed by the operator's cluster label
+		owners := make(map[string]map[string]bool)
+		for _, name := range pvcs {
+			pvc, err := s.clientset.CoreV1().PersistentVolumeClaims(ns).Get(ctx, name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to get PVC %s/%s: %w", ns, name, err)
+			}
+			addClusterLabels(owners, pvc)
+		}
+		if len(owners) == 0 {
+			continue
+		}
+
+		for _, gk := range slices.SortedFunc(maps.Keys(operatorHandlers), compareGroupKinds) {
+			handler := operatorHandlers[gk]
+			if len(owners[handler.clusterLabel]) == 0 {
+				continue
+			}
+			mapping, err := s.mapper.RESTMapping(gk)
+			if meta.IsNoMatchError(err) {
+				// The operator isn't installed
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to find resource for %s: %w", gk, err)
+			}
+
+			resource := s.dynamic.Resource(mapping.Resource).Namespace(ns)
+			err = kube.EachListItem(ctx, s.paging, metav1.ListOptions{},
+				func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+					return resource.List(ctx, opts)
+				}, func(obj *unstructured.Unstructured) error {
+					_, quiesced := obj.GetAnnotations()[common.AnnotationOriginalOperatorState]
+					if quiesced && owners[handler.clusterLabel][obj.GetName()] {
+						apiVersion, kind := mapping.GroupVersionKind.ToAPIVersionAndKind()
+						controllers = append(controllers, common.ControllerRef{
+							APIVersion: apiVersion,
+							Kind:       kind,
+							Namespace:  ns,
+							Name:       obj.GetName(),
+						})
+					}
+					return nil
+				})
+			if err != nil {
+				return nil, fmt.Errorf("failed to list %s: %w", mapping.Resource.GroupResource(), err)
+			}
+		}
+	}
+	return controllers, nil
+}
+
+func addClusterLabels(owners map[string]map[string]bool, pvc *corev1.PersistentVolumeClaim) {
+	for _, handler := range operatorHandlers {
+		name, ok := pvc.Labels[handler.clusterLabel]
+		if !ok {
+			continue
+		}
+		if owners[handler.clusterLabel] == nil {
+			owners[handler.clusterLabel] = make(map[string]bool)
+		}
+		owners[handler.clusterLabel][name] = true
+	}
+}
+
+func compareGroupKinds(a, b schema.GroupKind) int {
+	return cmp.Or(strings.Compare(a.Group, b.Group), strings.Compare(a.Kind, b.Kind))
+}
+
+// controlledStatefulSets returns the StatefulSets controlled by the resource.
+func (s Scaler) controlledStatefulSets(ctx context.Context, owner *unstructured.Unstructured) ([]common.ControllerRef, error) {
+	var statefulSets []common.ControllerRef
+	list := s.clientset.AppsV1().StatefulSets(owner.GetNamespace())
+	err := kube.EachListItem(ctx, s.paging, metav1.ListOptions{},
+		func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+			return list.List(ctx, opts)
+		}, func(sts *appsv1.StatefulSet) error {
+			if metav1.IsControlledBy(sts, owner) {
+				statefulSets = append(statefulSets, common.ControllerRef{
+					APIVersion: "apps/v1",
+					Kind:       common.KindStatefulSet,
+					Namespace:  sts.Namespace,
+					Name:       sts.Name,
+				})
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list statefulsets: %w", err)
+	}
+	return statefulSets, nil
+}
+
+// controls returns true if the resource controls any resources of the given kind, which isn't the case if
+// the kind isn't served.
+func (s Scaler) controls(ctx context.Context, owner *unstructured.Unstructured, gk schema.GroupKind) (bool, error) {
+	mapping, err := s.mapper.RESTMapping(gk)
+	if meta.IsNoMatchError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to find resource for %s: %w", gk, err)
+	}
+
+	controlled := false
+	resource := s.dynamic.Resource(mapping.Resource).Namespace(owner.GetNamespace())
+	err = kube.EachListItem(ctx, s.paging, metav1.ListOptions{},
+		func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+			return resource.List(ctx, opts)
+		}, func(obj *unstructured.Unstructured) error {
+			controlled = controlled || metav1.IsControlledBy(obj, owner)
+			return nil
+		})
+	if err != nil {
+		return false, fmt.Errorf("failed to list %s: %w", mapping.Resource.GroupResource(), err)
+	}
+	return controlled, nil
+}
+
+// resourceFor returns the dynamic client for the controller's resource.
+func (s Scaler) resourceFor(ctrl common.ControllerRef) (dynamic.ResourceInterface, error) {
+	gvk := ctrl.GroupVersionKind()
+	mapping, err := s.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find resource for %s: %w", gvk, err)
+	}
+	return s.dynamic.Resource(mapping.Resource).Namespace(ctrl.Namespace), nil
+}
+
+// isQuiesced compares the field's current value with its quiesced value. Values are compared as JSON, since
+// numbers may be decoded as any numeric type.
+func isQuiesced(current, quiesced any) bool {
+	a, errA := json.Marshal(current)
+	b, errB := json.Marshal(quiesced)
+	return errA == nil && errB == nil && bytes.Equal(a, b)
+}
+
+// decodeOriginalState decodes the value recorded in AnnotationOriginalOperatorState. Numbers are decoded as
+// json.Number, so that integers are patched back as they were.
+func decodeOriginalState(value string) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(value)))
+	decoder.UseNumber()
+	var original any
+	err := decoder.Decode(&original)
+	return original, err
+}
+
+// fieldPatch builds a JSON merge patch that sets the field at the given path, along with the annotation
+// recording the original state. A nil value removes the field, or the annotation.
+func fieldPatch(field []string, value, original any) []byte {
+	patch := map[string]any{}
+	// The path and values are plain JSON, so this can't fail
+	_ = unstructured.SetNestedField(patch, value, field...)
+	annotations, _, _ := unstructured.NestedMap(patch, "metadata", "annotations")
+	if annotations == nil {
+		annotations = map[string]any{}
+	}
+	annotations[common.AnnotationOriginalOperatorState] = original
+	_ = unstructured.SetNestedMap(patch, annotations, "metadata", "annotations")
+	data, _ := json.Marshal(patch)
+	return data
+}
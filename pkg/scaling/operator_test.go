@@ -0,0 +1,219 @@
+package scaling
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	"github.com/dancavallaro/kubectl-unmount/pkg/kube/kubetest"
+	"github.com/dancavallaro/kubectl-unmount/pkg/logger"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/utils/ptr"
+)
+
+func newCustomResource(apiVersion, kind string, fields map[string]any) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: fields}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetNamespace(testNamespace)
+	obj.SetName("test-cluster")
+	obj.SetUID("test-uid")
+	return obj
+}
+
+func getCustomResource(t *testing.T, clients kubetest.Clients, ctrl common.ControllerRef) *unstructured.Unstructured {
+	gvk := ctrl.GroupVersionKind()
+	mapping, err := clients.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	require.NoError(t, err)
+	obj, err := clients.FakeDynamic.Resource(mapping.Resource).Namespace(ctrl.Namespace).Get(context.Background(), ctrl.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	return obj
+}
+
+func TestQuiesceAndResumeOperator(t *testing.T) {
+	tests := []struct {
+		name         string
+		obj          *unstructured.Unstructured
+		field        []string
+		wantQuiesced any
+		// wantResumed is the field's value after resuming, or nil if it should be removed
+		wantResumed any
+	}{
+		{
+			name:         "CloudNativePG",
+			obj:          newCustomResource("postgresql.cnpg.io/v1", "Cluster", map[string]any{}),
+			field:        []string{"metadata", "annotations", "cnpg.io/hibernation"},
+			wantQuiesced: "on",
+		},
+		{
+			name: "CloudNativePG hibernated off",
+			obj: newCustomResource("postgresql.cnpg.io/v1", "Cluster", map[string]any{
+				"metadata": map[string]any{"annotations": map[string]any{"cnpg.io/hibernation": "off"}},
+			}),
+			field:        []string{"metadata", "annotations", "cnpg.io/hibernation"},
+			wantQuiesced: "on",
+			wantResumed:  "off",
+		},
+		{
+			name: "Zalando",
+			obj: newCustomResource("acid.zalan.do/v1", "postgresql", map[string]any{
+				"spec": map[string]any{"numberOfInstances": int64(3)},
+			}),
+			field:        []string{"spec", "numberOfInstances"},
+			wantQuiesced: int64(0),
+			wantResumed:  int64(3),
+		},
+		{
+			name: "Crunchy",
+			obj: newCustomResource("postgres-operator.crunchydata.com/v1beta1", "PostgresCluster", map[string]any{
+				"spec": map[string]any{"postgresVersion": int64(16)},
+			}),
+			field:        []string{"spec", "shutdown"},
+			wantQuiesced: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients := kubetest.NewClients(tt.obj)
+			scaler := New(clients.Clients, logger.NewLogger(io.Discard), Options{})
+			ctrl := common.ControllerRef{APIVersion: tt.obj.GetAPIVersion(), Kind: tt.obj.GetKind(), Namespace: testNamespace, Name: "test-cluster"}
+
+			desc, err := scaler.Describe(context.Background(), ctrl, nil)
+			require.NoError(t, err)
+			require.Equal(t, ActionQuiesce, desc.Action)
+
+			require.NoError(t, scaler.ScaleDown(context.Background(), ctrl))
+			obj := getCustomResource(t, clients, ctrl)
+			value, _, _ := unstructured.NestedFieldCopy(obj.Object, tt.field...)
+			require.Equal(t, tt.wantQuiesced, value)
+			require.Contains(t, obj.GetAnnotations(), common.AnnotationOriginalOperatorState)
+
+			// Scaling down again keeps the original state
+			require.NoError(t, scaler.ScaleDown(context.Background(), ctrl))
+
+			require.NoError(t, scaler.Restore(context.Background(), ctrl))
+			obj = getCustomResource(t, clients, ctrl)
+			value, found, _ := unstructured.NestedFieldCopy(obj.Object, tt.field...)
+			if tt.wantResumed == nil {
+				require.False(t, found, "field %v should be removed, but is %v", tt.field, value)
+			} else {
+				require.Equal(t, tt.wantResumed, value)
+			}
+			require.NotContains(t, obj.GetAnnotations(), common.AnnotationOriginalOperatorState)
+		})
+	}
+}
+
+func TestQuiesceStrimzi(t *testing.T) {
+	kafka := newCustomResource("kafka.strimzi.io/v1beta2", "Kafka", map[string]any{})
+	ctrl := common.ControllerRef{APIVersion: "kafka.strimzi.io/v1beta2", Kind: "Kafka", Namespace: testNamespace, Name: "test-cluster"}
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-cluster-kafka",
+			Namespace: testNamespace,
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: ctrl.APIVersion, Kind: ctrl.Kind, Name: ctrl.Name, UID: "test-uid", Controller: ptr.To(true),
+			}},
+		},
+		Spec: appsv1.StatefulSetSpec{Replicas: ptr.To[int32](3)},
+	}
+	clients := kubetest.NewClients(kafka, sts)
+	scaler := New(clients.Clients, logger.NewLogger(io.Discard), Options{})
+
+	getReplicas := func() int32 {
+		sts, err := clients.FakeClientset.AppsV1().StatefulSets(testNamespace).Get(context.Background(), sts.Name, metav1.GetOptions{})
+		require.NoError(t, err)
+		return *sts.Spec.Replicas
+	}
+
+	require.NoError(t, scaler.ScaleDown(context.Background(), ctrl))
+	require.Equal(t, "true", getCustomResource(t, clients, ctrl).GetAnnotations()["strimzi.io/pause-reconciliation"])
+	require.Equal(t, int32(0), getReplicas())
+
+	require.NoError(t, scaler.Restore(context.Background(), ctrl))
+	require.NotContains(t, getCustomResource(t, clients, ctrl).GetAnnotations(), "strimzi.io/pause-reconciliation")
+	require.Equal(t, int32(3), getReplicas())
+}
+
+func TestQuiesceStrimziPodSets(t *testing.T) {
+	kafka := newCustomResource("kafka.strimzi.io/v1beta2", "Kafka", map[string]any{})
+	ctrl := common.ControllerRef{APIVersion: "kafka.strimzi.io/v1beta2", Kind: "Kafka", Namespace: testNamespace, Name: "test-cluster"}
+	podSet := newCustomResource("core.strimzi.io/v1beta2", "StrimziPodSet", map[string]any{})
+	podSet.SetName("test-cluster-kafka")
+	podSet.SetUID("podset-uid")
+	podSet.SetOwnerReferences([]metav1.OwnerReference{{
+		APIVersion: ctrl.APIVersion, Kind: ctrl.Kind, Name: ctrl.Name, UID: "test-uid", Controller: ptr.To(true),
+	}})
+	clients := kubetest.NewClients(kafka, podSet)
+	scaler := New(clients.Clients, logger.NewLogger(io.Discard), Options{})
+
+	err := scaler.ScaleDown(context.Background(), ctrl)
+	require.EqualError(t, err, "cannot quiesce Kafka test-ns/test-cluster: its pods are run by StrimziPodSets, "+
+		"which Strimzi can't scale down")
+	// Reconciliation isn't paused, since the brokers would be left running anyway
+	require.Empty(t, getCustomResource(t, clients, ctrl).GetAnnotations())
+}
+
+func TestFindQuiescedOperators(t *testing.T) {
+	quiesced := newCustomResource("postgresql.cnpg.io/v1", "Cluster", map[string]any{
+		"metadata": map[string]any{"annotations": map[string]any{common.AnnotationOriginalOperatorState: "null"}},
+	})
+	running := newCustomResource("postgresql.cnpg.io/v1", "Cluster", map[string]any{})
+	running.SetName("other-cluster")
+	objs := []runtime.Object{
+		quiesced,
+		running,
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+			Name: "test-cluster-1", Namespace: testNamespace, Labels: map[string]string{"cnpg.io/cluster": "test-cluster"},
+		}},
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+			Name: "other-cluster-1", Namespace: testNamespace, Labels: map[string]string{"cnpg.io/cluster": "other-cluster"},
+		}},
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "unlabelled", Namespace: testNamespace}},
+	}
+	clients := kubetest.NewClients(objs...)
+	scaler := New(clients.Clients, logger.NewLogger(io.Discard), Options{})
+
+	controllers, err := scaler.FindQuiescedOperators(context.Background(), map[string][]string{
+		testNamespace: {"test-cluster-1", "other-cluster-1", "unlabelled"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []common.ControllerRef{
+		{APIVersion: "postgresql.cnpg.io/v1", Kind: "Cluster", Namespace: testNamespace, Name: "test-cluster"},
+	}, controllers)
+}
+
+func TestOperatorPermissions(t *testing.T) {
+	clients := kubetest.NewClients()
+	scaler := New(clients.Clients, logger.NewLogger(io.Discard), Options{})
+
+	for gk, handler := range operatorHandlers {
+		t.Run(handler.operator, func(t *testing.T) {
+			var version string
+			for _, gvk := range kubetest.CustomResources {
+				if gvk.GroupKind() == gk {
+					version = gvk.Version
+				}
+			}
+			ctrl := common.ControllerRef{
+				APIVersion: schema.GroupVersion{Group: gk.Group, Version: version}.String(),
+				Kind:       gk.Kind,
+				Namespace:  testNamespace,
+				Name:       "test-cluster",
+			}
+			perms, err := scaler.RequiredPermissions(ctrl)
+			require.NoError(t, err)
+			require.Equal(t, "get", perms[0].Verb)
+			require.Equal(t, "patch", perms[1].Verb)
+			require.Equal(t, gk.Group, perms[1].Group)
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package scaling
+
+import (
+	"encoding/json"
+)
+
+// annotationPatch builds a JSON merge patch that sets an annotation to the given value. A nil value
+// removes the annotation.
+func annotationPatch(key string, value any) []byte {
+	return mergePatch(map[string]any{key: value}, nil)
+}
+
+// mergePatch builds a JSON merge patch that sets the given annotations and spec fields. Nil values remove
+// the corresponding field.
+func mergePatch(annotations map[string]any, spec map[string]any) []byte {
+	patch := map[string]any{}
+	if annotations != nil {
+		patch["metadata"] = map[string]any{"annotations": annotations}
+	}
+	if spec != nil {
+		patch["spec"] = spec
+	}
+	// Marshalling a map of plain values can't fail
+	data, _ := json.Marshal(patch)
+	return data
+}
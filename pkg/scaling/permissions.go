@@ -0,0 +1,128 @@
+package scaling
+
+import (
+	"fmt"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	"github.com/dancavallaro/kubectl-unmount/pkg/kube"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// RequiredPermissions returns the API requests ScaleDown makes for the controller, so that they can be
+// checked before anything is changed.
+func (s Scaler) RequiredPermissions(ctrl common.ControllerRef) ([]kube.Permission, error) {
+	ns := ctrl.Namespace
+	switch ctrl.BuiltinKind() {
+	case common.KindPod:
+		return []kube.Permission{{Verb: "delete", Resource: "pods", Namespace: ns}}, nil
+	case common.KindCronJob:
+		// The CronJob's active Jobs are handled like any other Job
+		return append([]kube.Permission{
+			{Verb: "get", Group: "batch", Resource: "cronjobs", Namespace: ns},
+			{Verb: "patch", Group: "batch", Resource: "cronjobs", Namespace: ns},
+		}, s.jobPermissions(ns)...), nil
+	case common.KindJob:
+		return s.jobPermissions(ns), nil
+	case common.KindDaemonSet:
+		if !s.fenceDaemonSets {
+			return nil, nil
+		}
+		return []kube.Permission{
+			{Verb: "get", Group: "apps", Resource: "daemonsets", Namespace: ns},
+			{Verb: "patch", Group: "apps", Resource: "daemonsets", Namespace: ns},
+		}, nil
+	}
+
+	if IsVirtualMachine(ctrl) {
+		// VirtualMachineInstances are stopped through their VirtualMachine
+		return []kube.Permission{
+			{Verb: "get", Group: virtualMachineKind.Group, Resource: "virtualmachines", Namespace: ns},
+			{Verb: "patch", Group: virtualMachineKind.Group, Resource: "virtualmachines", Namespace: ns},
+		}, nil
+	}
+
+	gvk := ctrl.GroupVersionKind()
+	mapping, err := s.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find resource for %s: %w", gvk, err)
+	}
+	gr := mapping.Resource.GroupResource()
+	if handler, ok := operatorHandlerFor(ctrl); ok {
+		perms := []kube.Permission{
+			{Verb: "get", Group: gr.Group, Resource: gr.Resource, Namespace: ns},
+			{Verb: "patch", Group: gr.Group, Resource: gr.Resource, Namespace: ns},
+		}
+		if handler.scaleStatefulSets {
+			perms = append(perms,
+				kube.Permission{Verb: "list", Group: "apps", Resource: "statefulsets", Namespace: ns},
+				kube.Permission{Verb: "get", Group: "apps", Resource: "statefulsets", Subresource: "scale", Namespace: ns},
+				kube.Permission{Verb: "update", Group: "apps", Resource: "statefulsets", Subresource: "scale", Namespace: ns},
+				kube.Permission{Verb: "patch", Group: "apps", Resource: "statefulsets", Namespace: ns},
+			)
+		}
+		for _, gk := range handler.unscalable {
+			// Kinds which aren't served aren't listed
+			if mapping, err := s.mapper.RESTMapping(gk); err == nil {
+				gr := mapping.Resource.GroupResource()
+				perms = append(perms, kube.Permission{Verb: "list", Group: gr.Group, Resource: gr.Resource, Namespace: ns})
+			}
+		}
+		return perms, nil
+	}
+	// Whether a rule's selector matches isn't known without getting the resource, so any rule for the kind
+	// is assumed to apply
+	if s.hasRuleFor(ctrl) {
+		return []kube.Permission{
+			{Verb: "get", Group: gr.Group, Resource: gr.Resource, Namespace: ns},
+			{Verb: "patch", Group: gr.Group, Resource: gr.Resource, Namespace: ns},
+		}, nil
+	}
+	perms := []kube.Permission{
+		{Verb: "get", Group: gr.Group, Resource: gr.Resource, Subresource: "scale", Namespace: ns},
+		{Verb: "update", Group: gr.Group, Resource: gr.Resource, Subresource: "scale", Namespace: ns},
+		// The original replica count is recorded in an annotation
+		{Verb: "patch", Group: gr.Group, Resource: gr.Resource, Namespace: ns},
+	}
+	if s.pauseAutoscalers {
+		perms = append(perms,
+			kube.Permission{Verb: "list", Group: "autoscaling", Resource: "horizontalpodautoscalers", Namespace: ns},
+			kube.Permission{Verb: "patch", Group: "autoscaling", Resource: "horizontalpodautoscalers", Namespace: ns},
+		)
+		// ScaledObjects are only paused if KEDA is installed
+		if _, err := s.mapper.RESTMapping(schema.GroupKind{Group: scaledObjectsResource.Group, Kind: kindScaledObject}); err == nil {
+			perms = append(perms,
+				kube.Permission{Verb: "list", Group: scaledObjectsResource.Group, Resource: scaledObjectsResource.Resource, Namespace: ns},
+				kube.Permission{Verb: "patch", Group: scaledObjectsResource.Group, Resource: scaledObjectsResource.Resource, Namespace: ns},
+			)
+		}
+	}
+	return perms, nil
+}
+
+// jobPermissions returns the API requests made to handle a Job, depending on the Job action.
+func (s Scaler) jobPermissions(ns string) []kube.Permission {
+	switch s.jobAction {
+	case JobActionWait:
+		return []kube.Permission{{Verb: "get", Group: "batch", Resource: "jobs", Namespace: ns}}
+	case JobActionDelete:
+		return []kube.Permission{{Verb: "delete", Group: "batch", Resource: "jobs", Namespace: ns}}
+	default:
+		return []kube.Permission{
+			{Verb: "get", Group: "batch", Resource: "jobs", Namespace: ns},
+			{Verb: "patch", Group: "batch", Resource: "jobs", Namespace: ns},
+		}
+	}
+}
+
+// GitOpsPermissions returns the API requests SuspendGitOps makes for the owner.
+func (s Scaler) GitOpsPermissions(owner GitOpsOwner) ([]kube.Permission, error) {
+	mapping, err := s.mapper.RESTMapping(gitOpsGroupKinds[owner.Kind])
+	if err != nil {
+		return nil, fmt.Errorf("failed to find resource for %s: %w", owner.Kind, err)
+	}
+	gr := mapping.Resource.GroupResource()
+	return []kube.Permission{
+		{Verb: "get", Group: gr.Group, Resource: gr.Resource, Namespace: owner.Namespace},
+		{Verb: "patch", Group: gr.Group, Resource: gr.Resource, Namespace: owner.Namespace},
+	}, nil
+}
@@ -0,0 +1,444 @@
+package scaling
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	"github.com/dancavallaro/kubectl-unmount/pkg/kube"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+const (
+	// readyPollInterval is how often a resource quiesced by a handler rule is checked for readiness.
+	readyPollInterval = 2 * time.Second
+	// defaultReadyTimeout is how long a resource quiesced by a handler rule is waited for, unless the rule
+	// says otherwise.
+	defaultReadyTimeout = 5 * time.Minute
+)
+
+// PatchType is the type of a handler rule's patch.
+type PatchType string
+
+const (
+	// PatchTypeMerge is a JSON merge patch (RFC 7386).
+	PatchTypeMerge PatchType = "merge"
+	// PatchTypeJSON is a JSON patch (RFC 6902).
+	PatchTypeJSON PatchType = "json"
+)
+
+// PatchTypes are all supported patch types, in the order they're documented.
+var PatchTypes = []PatchType{PatchTypeMerge, PatchTypeJSON}
+
+var patchTypes = map[PatchType]types.PatchType{
+	PatchTypeMerge: types.MergePatchType,
+	PatchTypeJSON:  types.JSONPatchType,
+}
+
+// HandlerRule declares how to quiesce and restore resources of a kind which isn't natively supported,
+// typically loaded from the config file.
+type HandlerRule struct {
+	// APIVersion and Kind of the resources the rule applies to
+	APIVersion string `mapstructure:"apiVersion"`
+	Kind       string `mapstructure:"kind"`
+	// Selector optionally restricts the rule to resources with matching labels
+	Selector string `mapstructure:"selector"`
+	// Quiesce is the patch which quiesces a resource
+	Quiesce RulePatch `mapstructure:"quiesce"`
+	// Restore undoes Quiesce
+	Restore RuleRestore `mapstructure:"restore"`
+	// Ready, if set, is waited for after a resource is quiesced
+	Ready *RuleCondition `mapstructure:"ready"`
+}
+
+// RulePatch is a patch applied by a handler rule. The patch is given as a JSON (or YAML flow) string.
+type RulePatch struct {
+	Type  PatchType `mapstructure:"type"`
+	Patch string    `mapstructure:"patch"`
+}
+
+// RuleRestore restores a resource, either by applying a patch or by setting a field back to the value
+// it had before the resource was quiesced.
+type RuleRestore struct {
+	RulePatch `mapstructure:",squash"`
+	// Field is the path of the field to restore, e.g. [spec, paused]
+	Field []string `mapstructure:"field"`
+}
+
+// RuleCondition is satisfied by a resource with a status condition of the given type and status ("True"
+// by default), or with a field of the given value.
+type RuleCondition struct {
+	Type   string   `mapstructure:"type"`
+	Status string   `mapstructure:"status"`
+	Field  []string `mapstructure:"field"`
+	Value  string   `mapstructure:"value"`
+	// Timeout is how long to wait for the condition, 5 minutes by default
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+func (r HandlerRule) String() string {
+	return fmt.Sprintf("%s %s", r.APIVersion, r.Kind)
+}
+
+// Validate checks that the rule is complete and its selector and patches can be parsed.
+func (r HandlerRule) Validate() error {
+	if r.APIVersion == "" || r.Kind == "" {
+		return errors.New("apiVersion and kind are required")
+	}
+	if _, err := schema.ParseGroupVersion(r.APIVersion); err != nil {
+		return fmt.Errorf("invalid apiVersion: %w", err)
+	}
+	if _, err := labels.Parse(r.Selector); err != nil {
+		return fmt.Errorf("invalid selector: %w", err)
+	}
+	if err := r.Quiesce.validate(); err != nil {
+		return fmt.Errorf("invalid quiesce patch: %w", err)
+	}
+	switch {
+	case len(r.Restore.Field) > 0 && r.Restore.Patch != "":
+		return errors.New("restore must have either a patch or a field, not both")
+	case len(r.Restore.Field) == 0:
+		if err := r.Restore.validate(); err != nil {
+			return fmt.Errorf("invalid restore patch: %w", err)
+		}
+	}
+	if r.Ready != nil && (r.Ready.Type == "") == (len(r.Ready.Field) == 0) {
+		return errors.New("ready must have either a condition type or a field")
+	}
+	if r.Ready != nil && r.Ready.Timeout < 0 {
+		return errors.New("ready timeout must not be negative")
+	}
+	return nil
+}
+
+func (p RulePatch) validate() error {
+	if _, ok := patchTypes[p.patchType()]; !ok {
+		return fmt.Errorf("invalid type %q, must be one of %v", p.Type, PatchTypes)
+	}
+	if p.Patch == "" {
+		return errors.New("patch is required")
+	}
+	data, err := p.data()
+	if err != nil {
+		return err
+	}
+	// The patch must have the right shape for the resource's annotations to be added to it
+	if p.patchType() == PatchTypeJSON {
+		var ops []any
+		if err := json.Unmarshal(data, &ops); err != nil {
+			return errors.New("a JSON patch must be a list of operations")
+		}
+	} else {
+		var patch map[string]any
+		if err := json.Unmarshal(data, &patch); err != nil {
+			return errors.New("a merge patch must be an object")
+		}
+	}
+	return nil
+}
+
+// patchType returns the patch's type, which is a merge patch by default.
+func (p RulePatch) patchType() PatchType {
+	if p.Type == "" {
+		return PatchTypeMerge
+	}
+	return p.Type
+}
+
+// data returns the patch as JSON, so that it can also be written as YAML.
+func (p RulePatch) data() ([]byte, error) {
+	var patch any
+	if err := yaml.Unmarshal([]byte(p.Patch), &patch); err != nil {
+		return nil, fmt.Errorf("failed to parse patch: %w", err)
+	}
+	return json.Marshal(patch)
+}
+
+// dataWithAnnotations returns the patch along with the given annotations of the resource, so that both are
+// applied in a single request. A nil value removes the annotation.
+func (p RulePatch) dataWithAnnotations(obj *unstructured.Unstructured, annotations map[string]any) ([]byte, error) {
+	data, err := p.data()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.patchType() == PatchTypeJSON {
+		var ops []any
+		if err := json.Unmarshal(data, &ops); err != nil {
+			return nil, fmt.Errorf("failed to parse patch: %w", err)
+		}
+		existing := obj.GetAnnotations()
+		if existing == nil {
+			// A JSON patch can't add a key to a map which doesn't exist, so the whole map is added
+			added := map[string]any{}
+			for key, value := range annotations {
+				if value != nil {
+					added[key] = value
+				}
+			}
+			ops = append(ops, map[string]any{"op": "add", "path": "/metadata/annotations", "value": added})
+			return json.Marshal(ops)
+		}
+		for _, key := range slices.Sorted(maps.Keys(annotations)) {
+			path := "/metadata/annotations/" + jsonPointerEscaper.Replace(key)
+			if annotations[key] != nil {
+				ops = append(ops, map[string]any{"op": "add", "path": path, "value": annotations[key]})
+			} else if _, ok := existing[key]; ok {
+				ops = append(ops, map[string]any{"op": "remove", "path": path})
+			}
+		}
+		return json.Marshal(ops)
+	}
+
+	var patch map[string]any
+	if err := json.Unmarshal(data, &patch); err != nil {
+		return nil, fmt.Errorf("failed to parse patch: %w", err)
+	}
+	if patch == nil {
+		patch = map[string]any{}
+	}
+	for key, value := range annotations {
+		if err := unstructured.SetNestedField(patch, value, "metadata", "annotations", key); err != nil {
+			return nil, fmt.Errorf("failed to add annotation %s to patch: %w", key, err)
+		}
+	}
+	return json.Marshal(patch)
+}
+
+// jsonPointerEscaper escapes a key for use in a JSON patch's path (RFC 6901).
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// matches returns true if the rule applies to the resource.
+func (r HandlerRule) matches(gvk schema.GroupVersionKind, obj metav1.Object) bool {
+	if r.APIVersion != gvk.GroupVersion().String() || r.Kind != gvk.Kind {
+		return false
+	}
+	// The selector was checked by Validate
+	selector, err := labels.Parse(r.Selector)
+	return err == nil && selector.Matches(labels.Set(obj.GetLabels()))
+}
+
+// hasRuleFor returns true if any rule applies to resources of the controller's kind, regardless of their
+// labels.
+func (s Scaler) hasRuleFor(ctrl common.ControllerRef) bool {
+	return slices.ContainsFunc(s.rules, func(r HandlerRule) bool {
+		return r.APIVersion == ctrl.APIVersion && r.Kind == ctrl.Kind
+	})
+}
+
+// ruleFor returns the first rule which applies to the controller, along with the controller's resource. The
+// resource is looked up unless obj is already given.
+func (s Scaler) ruleFor(ctx context.Context, ctrl common.ControllerRef, obj *unstructured.Unstructured) (*HandlerRule, *unstructured.Unstructured, error) {
+	if !s.hasRuleFor(ctrl) {
+		return nil, nil, nil
+	}
+	if obj == nil {
+		resource, err := s.resourceFor(ctrl)
+		if err != nil {
+			return nil, nil, err
+		}
+		obj, err = resource.Get(ctx, ctrl.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get %s %s/%s: %w", ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
+		}
+	}
+	for i, rule := range s.rules {
+		if rule.matches(ctrl.GroupVersionKind(), obj) {
+			return &s.rules[i], obj, nil
+		}
+	}
+	return nil, nil, nil
+}
+
+// quiesceWithRule quiesces the resource by applying the rule's patch, then waits for it to be ready.
+func (s Scaler) quiesceWithRule(ctx context.Context, rule *HandlerRule, ctrl common.ControllerRef, obj *unstructured.Unstructured) error {
+	resource, err := s.resourceFor(ctrl)
+	if err != nil {
+		return err
+	}
+
+	if _, quiesced := obj.GetAnnotations()[common.AnnotationOriginalOperatorState]; quiesced {
+		s.log.Info("%s %s/%s is already quiesced", ctrl.Kind, ctrl.Namespace, ctrl.Name)
+	} else {
+		// The original state is recorded in the same request as the quiesce patch, so that the resource is
+		// never marked as quiesced without being quiesced. Rules restoring with a patch record null.
+		var current any
+		if len(rule.Restore.Field) > 0 {
+			current, _, _ = unstructured.NestedFieldCopy(obj.Object, rule.Restore.Field...)
+		}
+		original, err := json.Marshal(current)
+		if err != nil {
+			return fmt.Errorf("failed to save state of %s %s/%s: %w", ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
+		}
+		annotations := map[string]any{common.AnnotationOriginalOperatorState: string(original)}
+		if len(s.pvcs) > 0 {
+			annotations[common.AnnotationPVCs] = strings.Join(s.pvcs, ",")
+		}
+		data, err := rule.Quiesce.dataWithAnnotations(obj, annotations)
+		if err != nil {
+			return fmt.Errorf("failed to quiesce %s %s/%s: %w", ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
+		}
+		if _, err := resource.Patch(ctx, ctrl.Name, patchTypes[rule.Quiesce.patchType()], data, s.patchOptions()); err != nil {
+			return fmt.Errorf("failed to quiesce %s %s/%s: %w", ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
+		}
+		s.log.Info("  Quiesced %s %s/%s with handler rule for %v", ctrl.Kind, ctrl.Namespace, ctrl.Name, rule)
+	}
+
+	if rule.Ready == nil || s.dryRun == DryRunServer {
+		return nil
+	}
+	s.log.Info("  Waiting for %s %s/%s to be ready...", ctrl.Kind, ctrl.Namespace, ctrl.Name)
+	// The wait is bounded on its own, since it holds up scaling down other controllers, even without --timeout
+	timeout := rule.Ready.Timeout
+	if timeout == 0 {
+		timeout = defaultReadyTimeout
+	}
+	err = wait.PollUntilContextTimeout(ctx, readyPollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		obj, err := resource.Get(ctx, ctrl.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return rule.Ready.satisfiedBy(obj), nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed waiting for %s %s/%s to be ready: %w", ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
+	}
+	return nil
+}
+
+// restoreWithRule undoes quiesceWithRule. Resources which weren't quiesced by unmount are left alone.
+func (s Scaler) restoreWithRule(ctx context.Context, rule *HandlerRule, ctrl common.ControllerRef, obj *unstructured.Unstructured) error {
+	resource, err := s.resourceFor(ctrl)
+	if err != nil {
+		return err
+	}
+
+	value, ok := obj.GetAnnotations()[common.AnnotationOriginalOperatorState]
+	if !ok {
+		s.log.Info("%s %s/%s was not quiesced by unmount, skipping", ctrl.Kind, ctrl.Namespace, ctrl.Name)
+		return nil
+	}
+
+	restore := rule.Restore.RulePatch
+	if len(rule.Restore.Field) > 0 {
+		original, err := decodeOriginalState(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s annotation on %s %s/%s: %w",
+				common.AnnotationOriginalOperatorState, ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
+		}
+		// The field is set back with a merge patch, which can't fail to marshal since it's plain JSON
+		patch := map[string]any{}
+		_ = unstructured.SetNestedField(patch, original, rule.Restore.Field...)
+		data, _ := json.Marshal(patch)
+		restore = RulePatch{Type: PatchTypeMerge, Patch: string(data)}
+	}
+	data, err := restore.dataWithAnnotations(obj, map[string]any{
+		common.AnnotationOriginalOperatorState: nil,
+		common.AnnotationPVCs:                  nil,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restore %s %s/%s: %w", ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
+	}
+	if _, err := resource.Patch(ctx, ctrl.Name, patchTypes[restore.patchType()], data, s.patchOptions()); err != nil {
+		return fmt.Errorf("failed to restore %s %s/%s: %w", ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
+	}
+	s.log.Info("  Restored %s %s/%s with handler rule for %v", ctrl.Kind, ctrl.Namespace, ctrl.Name, rule)
+	return nil
+}
+
+// FindRuleQuiescedResources finds the resources which were quiesced by a handler rule, and which the rule
+// still applies to, whose pods mounted any of the given PVCs. Unlike built-in controllers, there's no way of
+// telling which volumes they use, so the PVCs recorded when they were quiesced are matched.
+func (s Scaler) FindRuleQuiescedResources(ctx context.Context, pvcsPerNs map[string][]string) ([]common.ControllerRef, error) {
+	var controllers []common.ControllerRef
+	for _, ns := range slices.Sorted(maps.Keys(pvcsPerNs)) {
+		var seen []schema.GroupVersionKind
+		for _, rule := range s.rules {
+			gvk := schema.FromAPIVersionAndKind(rule.APIVersion, rule.Kind)
+			if slices.Contains(seen, gvk) {
+				continue
+			}
+			seen = append(seen, gvk)
+
+			mapping, err := s.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+			if meta.IsNoMatchError(err) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to find resource for %s: %w", gvk, err)
+			}
+
+			resource := s.dynamic.Resource(mapping.Resource).Namespace(ns)
+			err = kube.EachListItem(ctx, s.paging, metav1.ListOptions{},
+				func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+					return resource.List(ctx, opts)
+				}, func(obj *unstructured.Unstructured) error {
+					_, quiesced := obj.GetAnnotations()[common.AnnotationOriginalOperatorState]
+					if quiesced && s.hasMatchingRule(gvk, obj) && mountedAnyPVC(obj, pvcsPerNs[ns]) {
+						controllers = append(controllers, common.ControllerRef{
+							APIVersion: rule.APIVersion,
+							Kind:       rule.Kind,
+							Namespace:  ns,
+							Name:       obj.GetName(),
+						})
+					}
+					return nil
+				})
+			if err != nil {
+				return nil, fmt.Errorf("failed to list %s: %w", mapping.Resource.GroupResource(), err)
+			}
+		}
+	}
+	return controllers, nil
+}
+
+// hasMatchingRule returns true if any rule applies to the resource.
+func (s Scaler) hasMatchingRule(gvk schema.GroupVersionKind, obj metav1.Object) bool {
+	return slices.ContainsFunc(s.rules, func(r HandlerRule) bool { return r.matches(gvk, obj) })
+}
+
+// mountedAnyPVC returns true if any of the given PVCs were recorded on the resource when it was quiesced.
+func mountedAnyPVC(obj metav1.Object, pvcs []string) bool {
+	recorded, ok := obj.GetAnnotations()[common.AnnotationPVCs]
+	if !ok {
+		return false
+	}
+	return slices.ContainsFunc(strings.Split(recorded, ","), func(pvc string) bool {
+		return slices.Contains(pvcs, pvc)
+	})
+}
+
+// satisfiedBy returns true if the resource meets the condition.
+func (c RuleCondition) satisfiedBy(obj *unstructured.Unstructured) bool {
+	if len(c.Field) > 0 {
+		value, found, _ := unstructured.NestedFieldNoCopy(obj.Object, c.Field...)
+		return found && fmt.Sprint(value) == c.Value
+	}
+
+	status := c.Status
+	if status == "" {
+		status = string(metav1.ConditionTrue)
+	}
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, condition := range conditions {
+		condition, ok := condition.(map[string]any)
+		if ok && condition["type"] == c.Type {
+			return condition["status"] == status
+		}
+	}
+	return false
+}
@@ -0,0 +1,333 @@
+package scaling
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	"github.com/dancavallaro/kubectl-unmount/pkg/kube/kubetest"
+	"github.com/dancavallaro/kubectl-unmount/pkg/logger"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var testWidget = common.ControllerRef{APIVersion: "example.com/v1", Kind: "Widget", Namespace: testNamespace, Name: "test-widget"}
+
+func newWidget(labels map[string]string, spec map[string]any) *unstructured.Unstructured {
+	obj := newCustomResource(testWidget.APIVersion, testWidget.Kind, map[string]any{"spec": spec})
+	obj.SetName(testWidget.Name)
+	obj.SetLabels(labels)
+	return obj
+}
+
+func TestValidateHandlerRule(t *testing.T) {
+	valid := HandlerRule{
+		APIVersion: "example.com/v1",
+		Kind:       "Widget",
+		Quiesce:    RulePatch{Patch: `{"spec": {"paused": true}}`},
+		Restore:    RuleRestore{Field: []string{"spec", "paused"}},
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(r *HandlerRule)
+		wantErr string
+	}{
+		{
+			name:   "valid",
+			modify: func(r *HandlerRule) {},
+		},
+		{
+			name: "YAML JSON patch restored by a patch",
+			modify: func(r *HandlerRule) {
+				r.Quiesce = RulePatch{Type: PatchTypeJSON, Patch: `[{op: replace, path: /spec/paused, value: true}]`}
+				r.Restore = RuleRestore{RulePatch: RulePatch{Patch: `{"spec": {"paused": false}}`}}
+			},
+		},
+		{
+			name:    "missing kind",
+			modify:  func(r *HandlerRule) { r.Kind = "" },
+			wantErr: "apiVersion and kind are required",
+		},
+		{
+			name:    "invalid selector",
+			modify:  func(r *HandlerRule) { r.Selector = "app in (" },
+			wantErr: "invalid selector",
+		},
+		{
+			name:    "invalid patch type",
+			modify:  func(r *HandlerRule) { r.Quiesce.Type = "strategic" },
+			wantErr: `invalid quiesce patch: invalid type "strategic", must be one of [merge json]`,
+		},
+		{
+			name:    "invalid patch",
+			modify:  func(r *HandlerRule) { r.Quiesce.Patch = `{"spec": ` },
+			wantErr: "invalid quiesce patch: failed to parse patch",
+		},
+		{
+			name:    "merge patch which isn't an object",
+			modify:  func(r *HandlerRule) { r.Quiesce.Patch = `[{"op": "remove", "path": "/spec"}]` },
+			wantErr: "invalid quiesce patch: a merge patch must be an object",
+		},
+		{
+			name:    "JSON patch which isn't a list",
+			modify:  func(r *HandlerRule) { r.Quiesce = RulePatch{Type: PatchTypeJSON, Patch: `{"spec": {"paused": true}}`} },
+			wantErr: "invalid quiesce patch: a JSON patch must be a list of operations",
+		},
+		{
+			name:    "restore patch and field",
+			modify:  func(r *HandlerRule) { r.Restore.Patch = `{"spec": {"paused": false}}` },
+			wantErr: "restore must have either a patch or a field, not both",
+		},
+		{
+			name:    "no restore",
+			modify:  func(r *HandlerRule) { r.Restore = RuleRestore{} },
+			wantErr: "invalid restore patch: patch is required",
+		},
+		{
+			name:    "empty ready condition",
+			modify:  func(r *HandlerRule) { r.Ready = &RuleCondition{} },
+			wantErr: "ready must have either a condition type or a field",
+		},
+		{
+			name:    "negative ready timeout",
+			modify:  func(r *HandlerRule) { r.Ready = &RuleCondition{Type: "Paused", Timeout: -time.Second} },
+			wantErr: "ready timeout must not be negative",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := valid
+			tt.modify(&rule)
+			err := rule.Validate()
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestQuiesceAndRestoreWithRule(t *testing.T) {
+	tests := []struct {
+		name         string
+		rule         HandlerRule
+		annotations  map[string]string
+		spec         map[string]any
+		wantQuiesced map[string]any
+		wantRestored map[string]any
+	}{
+		{
+			name: "merge patch restored by a field",
+			rule: HandlerRule{
+				Quiesce: RulePatch{Type: PatchTypeMerge, Patch: `{"spec": {"paused": true}}`},
+				Restore: RuleRestore{Field: []string{"spec", "paused"}},
+			},
+			spec:         map[string]any{"paused": false},
+			wantQuiesced: map[string]any{"paused": true},
+			wantRestored: map[string]any{"paused": false},
+		},
+		{
+			name: "unset field is removed on restore",
+			rule: HandlerRule{
+				Quiesce: RulePatch{Patch: `{"spec": {"paused": true}}`},
+				Restore: RuleRestore{Field: []string{"spec", "paused"}},
+			},
+			spec:         map[string]any{"size": int64(3)},
+			wantQuiesced: map[string]any{"size": int64(3), "paused": true},
+			wantRestored: map[string]any{"size": int64(3)},
+		},
+		{
+			name: "JSON patch restored by a patch",
+			rule: HandlerRule{
+				Quiesce: RulePatch{Type: PatchTypeJSON, Patch: `[{"op": "replace", "path": "/spec/runStrategy", "value": "Halted"}]`},
+				Restore: RuleRestore{RulePatch: RulePatch{Patch: `{"spec": {"runStrategy": "Always"}}`}},
+			},
+			spec:         map[string]any{"runStrategy": "Always"},
+			wantQuiesced: map[string]any{"runStrategy": "Halted"},
+			wantRestored: map[string]any{"runStrategy": "Always"},
+		},
+		{
+			name: "JSON patch of a resource with annotations",
+			rule: HandlerRule{
+				Quiesce: RulePatch{Type: PatchTypeJSON, Patch: `[{"op": "replace", "path": "/spec/runStrategy", "value": "Halted"}]`},
+				Restore: RuleRestore{RulePatch: RulePatch{
+					Type: PatchTypeJSON, Patch: `[{"op": "replace", "path": "/spec/runStrategy", "value": "Always"}]`,
+				}},
+			},
+			annotations:  map[string]string{"example.com/owner": "team-a"},
+			spec:         map[string]any{"runStrategy": "Always"},
+			wantQuiesced: map[string]any{"runStrategy": "Halted"},
+			wantRestored: map[string]any{"runStrategy": "Always"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.APIVersion, tt.rule.Kind = testWidget.APIVersion, testWidget.Kind
+			require.NoError(t, tt.rule.Validate())
+			widget := newWidget(nil, tt.spec)
+			widget.SetAnnotations(tt.annotations)
+			clients := kubetest.NewClients(widget)
+			scaler := New(clients.Clients, logger.NewLogger(io.Discard), Options{HandlerRules: []HandlerRule{tt.rule}})
+
+			desc, err := scaler.Describe(context.Background(), testWidget, nil)
+			require.NoError(t, err)
+			require.Equal(t, ActionQuiesce, desc.Action)
+
+			require.NoError(t, scaler.WithPVCs([]string{"data-0", "data-1"}).ScaleDown(context.Background(), testWidget))
+			obj := getCustomResource(t, clients, testWidget)
+			require.Equal(t, tt.wantQuiesced, obj.Object["spec"])
+			require.Contains(t, obj.GetAnnotations(), common.AnnotationOriginalOperatorState)
+			require.Equal(t, "data-0,data-1", obj.GetAnnotations()[common.AnnotationPVCs])
+
+			// Quiescing again doesn't record the quiesced state as the original one
+			require.NoError(t, scaler.ScaleDown(context.Background(), testWidget))
+
+			require.NoError(t, scaler.Restore(context.Background(), testWidget))
+			obj = getCustomResource(t, clients, testWidget)
+			require.Equal(t, tt.wantRestored, obj.Object["spec"])
+			require.NotContains(t, obj.GetAnnotations(), common.AnnotationOriginalOperatorState)
+			require.NotContains(t, obj.GetAnnotations(), common.AnnotationPVCs)
+			for key, value := range tt.annotations {
+				require.Equal(t, value, obj.GetAnnotations()[key])
+			}
+		})
+	}
+}
+
+func TestQuiesceWithRuleFailed(t *testing.T) {
+	rule := HandlerRule{
+		APIVersion: testWidget.APIVersion,
+		Kind:       testWidget.Kind,
+		Quiesce:    RulePatch{Patch: `{"spec": {"paused": true}}`},
+		Restore:    RuleRestore{Field: []string{"spec", "paused"}},
+	}
+	clients := kubetest.NewClients(newWidget(nil, map[string]any{"paused": false}))
+	clients.FakeDynamic.PrependReactor("patch", "widgets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("denied by admission webhook")
+	})
+	scaler := New(clients.Clients, logger.NewLogger(io.Discard), Options{HandlerRules: []HandlerRule{rule}})
+
+	require.ErrorContains(t, scaler.ScaleDown(context.Background(), testWidget), "denied by admission webhook")
+	// The resource isn't marked as quiesced, so the next run tries again
+	obj := getCustomResource(t, clients, testWidget)
+	require.NotContains(t, obj.GetAnnotations(), common.AnnotationOriginalOperatorState)
+	require.Equal(t, map[string]any{"paused": false}, obj.Object["spec"])
+}
+
+func TestRuleSelector(t *testing.T) {
+	rule := HandlerRule{
+		APIVersion: testWidget.APIVersion,
+		Kind:       testWidget.Kind,
+		Selector:   "app=db",
+		Quiesce:    RulePatch{Patch: `{"spec": {"paused": true}}`},
+		Restore:    RuleRestore{Field: []string{"spec", "paused"}},
+	}
+
+	for _, tt := range []struct {
+		name      string
+		labels    map[string]string
+		wantMatch bool
+	}{
+		{name: "matching labels", labels: map[string]string{"app": "db"}, wantMatch: true},
+		{name: "other labels", labels: map[string]string{"app": "web"}},
+		{name: "no labels"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			clients := kubetest.NewClients(newWidget(tt.labels, nil))
+			scaler := New(clients.Clients, logger.NewLogger(io.Discard), Options{HandlerRules: []HandlerRule{rule}})
+
+			got, _, err := scaler.ruleFor(context.Background(), testWidget, nil)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantMatch, got != nil)
+		})
+	}
+}
+
+func TestRuleReadyCondition(t *testing.T) {
+	conditions := []any{
+		map[string]any{"type": "Ready", "status": "False"},
+		map[string]any{"type": "Paused", "status": "True"},
+	}
+	obj := newWidget(nil, map[string]any{"replicas": int64(0)})
+	obj.Object["status"] = map[string]any{"conditions": conditions, "phase": "Stopped"}
+
+	tests := []struct {
+		name      string
+		condition RuleCondition
+		want      bool
+	}{
+		{name: "condition true", condition: RuleCondition{Type: "Paused"}, want: true},
+		{name: "condition with status", condition: RuleCondition{Type: "Ready", Status: "False"}, want: true},
+		{name: "condition not true", condition: RuleCondition{Type: "Ready"}},
+		{name: "missing condition", condition: RuleCondition{Type: "Hibernated"}},
+		{name: "field", condition: RuleCondition{Field: []string{"status", "phase"}, Value: "Stopped"}, want: true},
+		{name: "numeric field", condition: RuleCondition{Field: []string{"spec", "replicas"}, Value: "0"}, want: true},
+		{name: "field with another value", condition: RuleCondition{Field: []string{"status", "phase"}, Value: "Running"}},
+		{name: "missing field", condition: RuleCondition{Field: []string{"status", "ready"}, Value: ""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, tt.condition.satisfiedBy(obj))
+		})
+	}
+}
+
+func TestQuiesceWithRuleReadyTimeout(t *testing.T) {
+	rule := HandlerRule{
+		APIVersion: testWidget.APIVersion,
+		Kind:       testWidget.Kind,
+		Quiesce:    RulePatch{Patch: `{"spec": {"paused": true}}`},
+		Restore:    RuleRestore{Field: []string{"spec", "paused"}},
+		Ready:      &RuleCondition{Type: "Paused", Timeout: 10 * time.Millisecond},
+	}
+	clients := kubetest.NewClients(newWidget(nil, nil))
+	scaler := New(clients.Clients, logger.NewLogger(io.Discard), Options{HandlerRules: []HandlerRule{rule}})
+
+	// The widget never becomes ready, which gives up after the rule's timeout even though ctx has none
+	err := scaler.ScaleDown(context.Background(), testWidget)
+	require.ErrorContains(t, err, "failed waiting for Widget test-ns/test-widget to be ready")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestFindRuleQuiescedResources(t *testing.T) {
+	newQuiesced := func(name string, labels map[string]string, pvcs string) *unstructured.Unstructured {
+		obj := newWidget(labels, nil)
+		obj.SetName(name)
+		obj.SetAnnotations(map[string]string{common.AnnotationOriginalOperatorState: "null", common.AnnotationPVCs: pvcs})
+		return obj
+	}
+	running := newWidget(map[string]string{"app": "db"}, nil)
+	running.SetName("running-widget")
+	objs := []runtime.Object{
+		newQuiesced(testWidget.Name, map[string]string{"app": "db"}, "data-0,test-pvc"),
+		newQuiesced("other-pvcs", map[string]string{"app": "db"}, "other-pvc"),
+		newQuiesced("other-labels", map[string]string{"app": "web"}, "test-pvc"),
+		running,
+	}
+	rule := HandlerRule{
+		APIVersion: testWidget.APIVersion,
+		Kind:       testWidget.Kind,
+		Selector:   "app=db",
+		Quiesce:    RulePatch{Patch: `{"spec": {"paused": true}}`},
+		Restore:    RuleRestore{Field: []string{"spec", "paused"}},
+	}
+	clients := kubetest.NewClients(objs...)
+	scaler := New(clients.Clients, logger.NewLogger(io.Discard), Options{HandlerRules: []HandlerRule{rule, rule}})
+
+	controllers, err := scaler.FindRuleQuiescedResources(context.Background(), map[string][]string{
+		testNamespace: {"test-pvc"},
+		"other-ns":    {"test-pvc"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []common.ControllerRef{testWidget}, controllers)
+}
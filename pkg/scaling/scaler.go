@@ -3,59 +3,208 @@ package scaling
 import (
 	"context"
 	"fmt"
+	"strconv"
 
 	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	"github.com/dancavallaro/kubectl-unmount/pkg/kube"
 	"github.com/dancavallaro/kubectl-unmount/pkg/logger"
+	appsv1 "k8s.io/api/apps/v1"
 	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/scale"
 )
 
+// Options controls how a Scaler handles controllers.
+type Options struct {
+	DryRun DryRunMode
+	// JobAction is what to do with Jobs (including the active Jobs of suspended CronJobs)
+	JobAction JobAction
+	// FenceDaemonSets enables stopping DaemonSets by patching them with a nodeSelector which matches no nodes
+	FenceDaemonSets bool
+	// PauseAutoscalers pauses HorizontalPodAutoscalers and KEDA ScaledObjects targeting scaled down controllers
+	PauseAutoscalers bool
+	// ArgoCDNamespace is the namespace of Argo CD Applications, unless their tracking ID says otherwise
+	ArgoCDNamespace string
+	// Paging configures how list calls page through results
+	Paging kube.Paging
+	// HandlerRules quiesce and restore kinds which aren't supported natively. Rules are expected to have
+	// been validated.
+	HandlerRules []HandlerRule
+}
+
 type Scaler struct {
-	clientset *kubernetes.Clientset
-	log       *logger.Logger
-	dryRun    bool
+	clientset        kubernetes.Interface
+	dynamic          dynamic.Interface
+	discovery        discovery.DiscoveryInterface
+	mapper           meta.RESTMapper
+	scales           scale.ScalesGetter
+	log              *logger.Logger
+	dryRun           DryRunMode
+	jobAction        JobAction
+	fenceDaemonSets  bool
+	pauseAutoscalers bool
+	argoCDNamespace  string
+	paging           kube.Paging
+	rules            []HandlerRule
+	pvcs             []string
 }
 
 // New creates a new Scaler instance.
-func New(clientset *kubernetes.Clientset, log *logger.Logger, dryRun bool) Scaler {
+func New(clients kube.Clients, log *logger.Logger, opts Options) Scaler {
+	jobAction := opts.JobAction
+	if jobAction == "" {
+		jobAction = JobActionSuspend
+	}
+	argoCDNamespace := opts.ArgoCDNamespace
+	if argoCDNamespace == "" {
+		argoCDNamespace = "argocd"
+	}
 	return Scaler{
-		clientset: clientset,
-		log:       log,
-		dryRun:    dryRun,
+		clientset:        clients.Kubernetes,
+		dynamic:          clients.Dynamic,
+		discovery:        clients.Discovery,
+		mapper:           clients.Mapper,
+		scales:           clients.Scales,
+		log:              log,
+		dryRun:           opts.DryRun,
+		jobAction:        jobAction,
+		fenceDaemonSets:  opts.FenceDaemonSets,
+		pauseAutoscalers: opts.PauseAutoscalers,
+		argoCDNamespace:  argoCDNamespace,
+		paging:           opts.Paging,
+		rules:            opts.HandlerRules,
 	}
 }
 
+// WithLogger returns a copy of the Scaler which logs to another logger, e.g. a buffered one when scaling
+// controllers concurrently.
+func (s Scaler) WithLogger(log *logger.Logger) Scaler {
+	s.log = log
+	return s
+}
+
+// WithPVCs returns a copy of the Scaler which records the given PVCs, mounted by the pods of the controller
+// it scales down, on resources quiesced by a handler rule.
+func (s Scaler) WithPVCs(pvcs []string) Scaler {
+	s.pvcs = pvcs
+	return s
+}
+
 func (s Scaler) ScaleDown(ctx context.Context, ctrl common.ControllerRef) error {
-	if s.dryRun {
+	switch s.dryRun {
+	case DryRunClient:
 		s.log.Info("  (dry-run, skipping controller: %v)", ctrl)
 		return nil
+	case DryRunServer:
+		s.log.Info("  (server dry-run, changes to %v won't be persisted)", ctrl)
 	}
 
-	switch ctrl.Kind {
+	switch ctrl.BuiltinKind() {
 	case common.KindDeployment:
-		return scaleControllerToZero(ctx, s.log, s.clientset.AppsV1().Deployments(ctrl.Namespace), ctrl)
+		return scaleControllerToZero[*appsv1.Deployment](ctx, s, s.clientset.AppsV1().Deployments(ctrl.Namespace), ctrl)
 	case common.KindStatefulSet:
-		return scaleControllerToZero(ctx, s.log, s.clientset.AppsV1().StatefulSets(ctrl.Namespace), ctrl)
+		return scaleControllerToZero[*appsv1.StatefulSet](ctx, s, s.clientset.AppsV1().StatefulSets(ctrl.Namespace), ctrl)
 	case common.KindReplicaSet:
-		return scaleControllerToZero(ctx, s.log, s.clientset.AppsV1().ReplicaSets(ctrl.Namespace), ctrl)
+		return scaleControllerToZero[*appsv1.ReplicaSet](ctx, s, s.clientset.AppsV1().ReplicaSets(ctrl.Namespace), ctrl)
 	case common.KindPod:
-		return deletePod(ctx, s.log, s.clientset, ctrl)
+		return s.deletePod(ctx, ctrl)
+	case common.KindCronJob:
+		return s.suspendCronJob(ctx, ctrl)
+	case common.KindJob:
+		return s.handleJob(ctx, ctrl)
 	case common.KindDaemonSet:
-		s.log.Warn("Cannot scale down DaemonSet %s/%s (DaemonSets cannot be scaled)", ctrl.Namespace, ctrl.Name)
+		if s.fenceDaemonSets {
+			return s.fenceDaemonSet(ctx, ctrl)
+		}
+		s.log.Warn("Cannot scale down DaemonSet %s/%s (DaemonSets cannot be scaled, use --fence-daemonsets)", ctrl.Namespace, ctrl.Name)
 		return nil
 	default:
-		s.log.Warn("Unsupported controller type %s for %s/%s, skipping", ctrl.Kind, ctrl.Namespace, ctrl.Name)
+		if IsVirtualMachine(ctrl) {
+			return s.haltVirtualMachine(ctx, ctrl)
+		}
+		if handler, ok := operatorHandlerFor(ctrl); ok {
+			return s.quiesceOperator(ctx, handler, ctrl)
+		}
+		rule, obj, err := s.ruleFor(ctx, ctrl, nil)
+		if err != nil {
+			return err
+		}
+		if rule != nil {
+			return s.quiesceWithRule(ctx, rule, ctrl, obj)
+		}
+		return s.scaleGenericToZero(ctx, ctrl)
+	}
+}
+
+// Restore scales a controller that was previously scaled down back to its original replica count, or
+// resumes it if it was suspended, quiesced or halted.
+func (s Scaler) Restore(ctx context.Context, ctrl common.ControllerRef) error {
+	switch s.dryRun {
+	case DryRunClient:
+		s.log.Info("  (dry-run, skipping controller: %v)", ctrl)
 		return nil
+	case DryRunServer:
+		s.log.Info("  (server dry-run, changes to %v won't be persisted)", ctrl)
+	}
+
+	switch ctrl.BuiltinKind() {
+	case common.KindDeployment:
+		return restoreController[*appsv1.Deployment](ctx, s, s.clientset.AppsV1().Deployments(ctrl.Namespace), ctrl)
+	case common.KindStatefulSet:
+		return restoreController[*appsv1.StatefulSet](ctx, s, s.clientset.AppsV1().StatefulSets(ctrl.Namespace), ctrl)
+	case common.KindReplicaSet:
+		return restoreController[*appsv1.ReplicaSet](ctx, s, s.clientset.AppsV1().ReplicaSets(ctrl.Namespace), ctrl)
+	case common.KindCronJob:
+		return restoreSuspended[*batchv1.CronJob](ctx, s, s.clientset.BatchV1().CronJobs(ctrl.Namespace), ctrl)
+	case common.KindJob:
+		return restoreSuspended[*batchv1.Job](ctx, s, s.clientset.BatchV1().Jobs(ctrl.Namespace), ctrl)
+	case common.KindDaemonSet:
+		return s.restoreDaemonSet(ctx, ctrl)
+	default:
+		if IsVirtualMachine(ctrl) {
+			return s.restoreVirtualMachine(ctx, ctrl)
+		}
+		if handler, ok := operatorHandlerFor(ctrl); ok {
+			return s.resumeOperator(ctx, handler, ctrl)
+		}
+		rule, obj, err := s.ruleFor(ctx, ctrl, nil)
+		if err != nil {
+			return err
+		}
+		if rule != nil {
+			return s.restoreWithRule(ctx, rule, ctrl, obj)
+		}
+		return s.restoreGeneric(ctx, ctrl)
+	}
+}
+
+// Restorable returns false for controllers which ScaleDown deletes, since Restore can't bring them back.
+func (s Scaler) Restorable(ctrl common.ControllerRef) bool {
+	switch ctrl.BuiltinKind() {
+	case common.KindPod:
+		return false
+	case common.KindJob:
+		return s.jobAction != JobActionDelete
+	default:
+		return true
 	}
 }
 
-type scalable interface {
+type scalable[T metav1.Object] interface {
+	Get(ctx context.Context, name string, options metav1.GetOptions) (T, error)
+	Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (T, error)
 	GetScale(ctx context.Context, deploymentName string, options metav1.GetOptions) (*autoscalingv1.Scale, error)
 	UpdateScale(ctx context.Context, deploymentName string, scale *autoscalingv1.Scale, opts metav1.UpdateOptions) (*autoscalingv1.Scale, error)
 }
 
-func scaleControllerToZero(ctx context.Context, log *logger.Logger, scaler scalable, ctrl common.ControllerRef) error {
+func scaleControllerToZero[T metav1.Object](ctx context.Context, s Scaler, scaler scalable[T], ctrl common.ControllerRef) error {
+	log := s.log
 	scale, err := scaler.GetScale(ctx, ctrl.Name, metav1.GetOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to get scale for %s %s/%s: %w", ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
@@ -67,8 +216,21 @@ func scaleControllerToZero(ctx context.Context, log *logger.Logger, scaler scala
 		return nil
 	}
 
+	// Record the original replica count before scaling down, so it's never lost if scaling succeeds
+	patch := annotationPatch(common.AnnotationOriginalReplicas, strconv.Itoa(int(originalReplicas)))
+	if _, err := scaler.Patch(ctx, ctrl.Name, types.MergePatchType, patch, s.patchOptions()); err != nil {
+		return fmt.Errorf("failed to record original replicas for %s %s/%s: %w", ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
+	}
+
+	// Pause autoscalers first, so they don't scale the controller straight back up
+	if s.pauseAutoscalers {
+		if err := s.pauseAutoscalersFor(ctx, ctrl); err != nil {
+			return err
+		}
+	}
+
 	scale.Spec.Replicas = 0
-	_, err = scaler.UpdateScale(ctx, ctrl.Name, scale, metav1.UpdateOptions{})
+	_, err = scaler.UpdateScale(ctx, ctrl.Name, scale, s.updateOptions())
 	if err != nil {
 		return fmt.Errorf("failed to scale down %s %s/%s: %w", ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
 	}
@@ -77,11 +239,51 @@ func scaleControllerToZero(ctx context.Context, log *logger.Logger, scaler scala
 	return nil
 }
 
-func deletePod(ctx context.Context, log *logger.Logger, clientset *kubernetes.Clientset, ctrl common.ControllerRef) error {
-	err := clientset.CoreV1().Pods(ctrl.Namespace).Delete(ctx, ctrl.Name, metav1.DeleteOptions{})
+func restoreController[T metav1.Object](ctx context.Context, s Scaler, scaler scalable[T], ctrl common.ControllerRef) error {
+	log := s.log
+	obj, err := scaler.Get(ctx, ctrl.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get %s %s/%s: %w", ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
+	}
+
+	value, ok := obj.GetAnnotations()[common.AnnotationOriginalReplicas]
+	if !ok {
+		log.Info("%s %s/%s has no recorded replica count, skipping", ctrl.Kind, ctrl.Namespace, ctrl.Name)
+		return nil
+	}
+	originalReplicas, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid %s annotation %q on %s %s/%s: %w",
+			common.AnnotationOriginalReplicas, value, ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
+	}
+
+	scale, err := scaler.GetScale(ctx, ctrl.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get scale for %s %s/%s: %w", ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
+	}
+
+	currentReplicas := scale.Spec.Replicas
+	scale.Spec.Replicas = int32(originalReplicas)
+	if _, err := scaler.UpdateScale(ctx, ctrl.Name, scale, s.updateOptions()); err != nil {
+		return fmt.Errorf("failed to restore %s %s/%s: %w", ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
+	}
+
+	patch := annotationPatch(common.AnnotationOriginalReplicas, nil)
+	if _, err := scaler.Patch(ctx, ctrl.Name, types.MergePatchType, patch, s.patchOptions()); err != nil {
+		return fmt.Errorf("failed to clear original replicas for %s %s/%s: %w", ctrl.Kind, ctrl.Namespace, ctrl.Name, err)
+	}
+
+	log.Info("  Restored %s %s/%s from %d to %d replicas", ctrl.Kind, ctrl.Namespace, ctrl.Name, currentReplicas, originalReplicas)
+
+	// Resume autoscalers after restoring the replica count, so they start from where the controller was
+	return s.resumeAutoscalersFor(ctx, ctrl)
+}
+
+func (s Scaler) deletePod(ctx context.Context, ctrl common.ControllerRef) error {
+	err := s.clientset.CoreV1().Pods(ctrl.Namespace).Delete(ctx, ctrl.Name, s.deleteOptions())
 	if err != nil {
 		return fmt.Errorf("failed to delete pod %s/%s: %w", ctrl.Namespace, ctrl.Name, err)
 	}
-	log.Info("  Deleted standalone Pod %s/%s", ctrl.Namespace, ctrl.Name)
+	s.log.Info("  Deleted standalone Pod %s/%s (not restorable)", ctrl.Namespace, ctrl.Name)
 	return nil
 }
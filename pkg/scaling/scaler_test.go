@@ -0,0 +1,186 @@
+package scaling
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/dancavallaro/kubectl-unmount/pkg/common"
+	"github.com/dancavallaro/kubectl-unmount/pkg/kube/kubetest"
+	"github.com/dancavallaro/kubectl-unmount/pkg/logger"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/utils/ptr"
+)
+
+const testNamespace = "test-ns"
+
+var (
+	testDeployment = common.ControllerRef{APIVersion: "apps/v1", Kind: common.KindDeployment, Namespace: testNamespace, Name: "test-deployment"}
+	testPod        = common.ControllerRef{APIVersion: "v1", Kind: common.KindPod, Namespace: testNamespace, Name: "test-pod"}
+)
+
+func newDeployment(replicas int32, annotations map[string]string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: testDeployment.Name, Namespace: testNamespace, Annotations: annotations},
+		Spec:       appsv1.DeploymentSpec{Replicas: ptr.To(replicas)},
+	}
+}
+
+func getDeployment(t *testing.T, clients kubetest.Clients) *appsv1.Deployment {
+	deployment, err := clients.FakeClientset.AppsV1().Deployments(testNamespace).Get(context.Background(), testDeployment.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	return deployment
+}
+
+func TestScaleDownDeployment(t *testing.T) {
+	tests := []struct {
+		name            string
+		replicas        int32
+		wantAnnotations map[string]string
+	}{
+		{
+			name:            "scales to zero and records replicas",
+			replicas:        3,
+			wantAnnotations: map[string]string{common.AnnotationOriginalReplicas: "3"},
+		},
+		{
+			name:     "already scaled to zero",
+			replicas: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients := kubetest.NewClients(newDeployment(tt.replicas, nil))
+			scaler := New(clients.Clients, logger.NewLogger(io.Discard), Options{})
+
+			require.NoError(t, scaler.ScaleDown(context.Background(), testDeployment))
+
+			deployment := getDeployment(t, clients)
+			require.Equal(t, int32(0), *deployment.Spec.Replicas)
+			require.Equal(t, tt.wantAnnotations, deployment.Annotations)
+		})
+	}
+}
+
+func TestRestoreDeployment(t *testing.T) {
+	tests := []struct {
+		name         string
+		annotations  map[string]string
+		wantReplicas int32
+		wantErr      bool
+	}{
+		{
+			name:         "restores recorded replicas",
+			annotations:  map[string]string{common.AnnotationOriginalReplicas: "3"},
+			wantReplicas: 3,
+		},
+		{
+			name:         "no recorded replicas",
+			wantReplicas: 0,
+		},
+		{
+			name:         "invalid recorded replicas",
+			annotations:  map[string]string{common.AnnotationOriginalReplicas: "three"},
+			wantReplicas: 0,
+			wantErr:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients := kubetest.NewClients(newDeployment(0, tt.annotations))
+			scaler := New(clients.Clients, logger.NewLogger(io.Discard), Options{})
+
+			err := scaler.Restore(context.Background(), testDeployment)
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			deployment := getDeployment(t, clients)
+			require.Equal(t, tt.wantReplicas, *deployment.Spec.Replicas)
+			if !tt.wantErr {
+				require.NotContains(t, deployment.Annotations, common.AnnotationOriginalReplicas)
+			}
+		})
+	}
+}
+
+func TestClientDryRun(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: testPod.Name, Namespace: testNamespace}}
+	deployment := newDeployment(3, map[string]string{common.AnnotationOriginalReplicas: "5"})
+
+	for _, ctrl := range []common.ControllerRef{testDeployment, testPod} {
+		t.Run(ctrl.Kind, func(t *testing.T) {
+			clients := kubetest.NewClients(deployment, pod)
+			scaler := New(clients.Clients, logger.NewLogger(io.Discard), Options{DryRun: DryRunClient})
+
+			require.NoError(t, scaler.ScaleDown(context.Background(), ctrl))
+			require.NoError(t, scaler.Restore(context.Background(), ctrl))
+			require.Empty(t, clients.FakeClientset.Actions())
+		})
+	}
+}
+
+func TestDeleteStandalonePod(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: testPod.Name, Namespace: testNamespace}}
+	clients := kubetest.NewClients(pod)
+	scaler := New(clients.Clients, logger.NewLogger(io.Discard), Options{})
+
+	require.False(t, scaler.Restorable(testPod))
+	require.NoError(t, scaler.ScaleDown(context.Background(), testPod))
+
+	_, err := clients.FakeClientset.CoreV1().Pods(testNamespace).Get(context.Background(), testPod.Name, metav1.GetOptions{})
+	require.True(t, apierrors.IsNotFound(err))
+}
+
+func TestScaleDownError(t *testing.T) {
+	clients := kubetest.NewClients(newDeployment(3, nil))
+	clients.FakeClientset.PrependReactor("update", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "scale" {
+			return false, nil, nil
+		}
+		return true, nil, errors.New("scale denied")
+	})
+	scaler := New(clients.Clients, logger.NewLogger(io.Discard), Options{})
+
+	err := scaler.ScaleDown(context.Background(), testDeployment)
+	require.ErrorContains(t, err, "failed to scale down Deployment test-ns/test-deployment: scale denied")
+
+	// The original replica count is recorded first, so a retry or restore still knows it
+	deployment := getDeployment(t, clients)
+	require.Equal(t, int32(3), *deployment.Spec.Replicas)
+	require.Equal(t, "3", deployment.Annotations[common.AnnotationOriginalReplicas])
+}
+
+func TestCustomResourceOfBuiltinKind(t *testing.T) {
+	ctrl := common.ControllerRef{APIVersion: "example.com/v1", Kind: common.KindDeployment, Namespace: testNamespace, Name: testDeployment.Name}
+	custom := newCustomResource(ctrl.APIVersion, ctrl.Kind, map[string]any{"spec": map[string]any{"paused": false}})
+	custom.SetName(ctrl.Name)
+	rule := HandlerRule{
+		APIVersion: ctrl.APIVersion,
+		Kind:       ctrl.Kind,
+		Quiesce:    RulePatch{Patch: `{"spec": {"paused": true}}`},
+		Restore:    RuleRestore{Field: []string{"spec", "paused"}},
+	}
+	clients := kubetest.NewClients(newDeployment(3, nil), custom)
+	scaler := New(clients.Clients, logger.NewLogger(io.Discard), Options{HandlerRules: []HandlerRule{rule}})
+
+	// The custom resource is handled by its rule, rather than as the apps/v1 Deployment of the same name
+	require.NoError(t, scaler.ScaleDown(context.Background(), ctrl))
+	require.Equal(t, map[string]any{"paused": true}, getCustomResource(t, clients, ctrl).Object["spec"])
+	require.Equal(t, int32(3), *getDeployment(t, clients).Spec.Replicas)
+
+	require.NoError(t, scaler.Restore(context.Background(), ctrl))
+	require.Equal(t, map[string]any{"paused": false}, getCustomResource(t, clients, ctrl).Object["spec"])
+	require.Empty(t, getDeployment(t, clients).Annotations)
+}
@@ -1,33 +1,100 @@
 package spinner
 
 import (
+	"context"
 	"time"
 
 	"github.com/briandowns/spinner"
 )
 
-func Wait(label string, until func() (bool, error), onErr func(error), interval time.Duration) <-chan struct{} {
-	ch := make(chan struct{})
+// Wait polls the until function every interval until it returns true. The returned channel receives nil
+// once done, or the context's error if it's cancelled first.
+func Wait(ctx context.Context, label string, until func() (bool, error), onErr func(error), interval time.Duration) <-chan error {
+	return WaitWithStatus(ctx, label, func() (bool, string, error) {
+		done, err := until()
+		return done, "", err
+	}, onErr, interval)
+}
 
-	go func() {
-		s := spinner.New(spinner.CharSets[70], 100*time.Millisecond)
-		s.Prefix = label
-		s.Start()
+// WaitWithStatus is like Wait, but the until function also returns a status message which is shown after
+// the spinner to report progress.
+func WaitWithStatus(ctx context.Context, label string, until func() (bool, string, error), onErr func(error), interval time.Duration) <-chan error {
+	return wait(label, func(s *spinner.Spinner) error {
+		return poll(ctx, s, until, onErr, interval)
+	})
+}
 
+// WaitForEvents is like WaitWithStatus, but checks the until function once at first and then whenever the
+// events channel receives a value, rather than polling.
+func WaitForEvents(ctx context.Context, label string, until func() (bool, string, error), onErr func(error), events <-chan struct{}) <-chan error {
+	return wait(label, func(s *spinner.Spinner) error {
+		if check(ctx, s, until, onErr) {
+			return nil
+		}
 		for {
-			done, err := until()
-			if err != nil {
-				onErr(err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-events:
 			}
-			if done {
-				break
+			if check(ctx, s, until, onErr) {
+				return nil
 			}
-			<-time.NewTimer(interval).C
 		}
+	})
+}
+
+func wait(label string, fn func(s *spinner.Spinner) error) <-chan error {
+	ch := make(chan error, 1)
 
+	go func() {
+		s := spinner.New(spinner.CharSets[70], 100*time.Millisecond)
+		s.Prefix = label
+		s.Start()
+
+		err := fn(s)
+
+		// Stop the spinner before returning, so it doesn't interfere with anything logged afterwards
 		s.Stop()
-		close(ch)
+		ch <- err
 	}()
 
 	return ch
 }
+
+func poll(ctx context.Context, s *spinner.Spinner, until func() (bool, string, error), onErr func(error), interval time.Duration) error {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		if check(ctx, s, until, onErr) {
+			return nil
+		}
+		timer.Reset(interval)
+	}
+}
+
+// check calls the until function once, reporting its status on the spinner. Returns true if it's done.
+func check(ctx context.Context, s *spinner.Spinner, until func() (bool, string, error), onErr func(error)) bool {
+	done, status, err := until()
+	if ctx.Err() != nil {
+		// Errors are expected once the context is cancelled, and the cancellation itself is returned by the caller
+		return false
+	}
+	if err != nil {
+		onErr(err)
+	}
+	if done {
+		return true
+	}
+	s.Lock()
+	s.Suffix = status
+	s.Unlock()
+	return false
+}